@@ -5,6 +5,7 @@ go 1.23.2
 require (
 	github.com/aws/aws-sdk-go v1.55.7
 	github.com/pdfcpu/pdfcpu v0.11.0
+	github.com/yuin/goldmark v1.8.6
 )
 
 require (
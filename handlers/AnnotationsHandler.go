@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/pageselect"
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+type AnnotationsRequest struct {
+	Mode    string               `json:"mode"`    // "list", "remove" or "flatten"; flatten also bakes form fields into the page
+	Types   []string             `json:"types"`   // annotation subtypes, e.g. "Highlight", "Text", "Ink"
+	Authors []string             `json:"authors"` // match the annotation author (T entry)
+	Pages   pageselect.Selection `json:"pages"`   // restrict to these pages
+}
+
+type AnnotationInfo struct {
+	ObjNr    int        `json:"objNr,omitempty"`
+	Type     string     `json:"type"`
+	Author   string     `json:"author,omitempty"`
+	Contents string     `json:"contents,omitempty"`
+	Rect     [4]float64 `json:"rect"`
+}
+
+type PageAnnotations struct {
+	Page        int              `json:"page"`
+	Annotations []AnnotationInfo `json:"annotations"`
+}
+
+// matches reports whether an annotation passes the type and author filters.
+// Empty filters match everything; comparison is case-insensitive.
+func (req AnnotationsRequest) matches(a AnnotationInfo) bool {
+	if len(req.Types) > 0 {
+		found := false
+		for _, t := range req.Types {
+			if strings.EqualFold(t, a.Type) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(req.Authors) > 0 {
+		found := false
+		for _, au := range req.Authors {
+			if strings.EqualFold(au, a.Author) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// collectAnnotations lists the annotations on the selected pages that pass
+// the request filters. Form widgets and popups are skipped: widgets belong
+// to form fields and popups are only the open/close state of their parent.
+func collectAnnotations(ctx *model.Context, req AnnotationsRequest) ([]PageAnnotations, error) {
+	pages, err := req.Pages.PagesOrAll(ctx.PageCount)
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(pages)
+
+	var result []PageAnnotations
+	for _, pageNr := range pages {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil {
+			continue
+		}
+		annots, err := ctx.DereferenceArray(pageDict["Annots"])
+		if err != nil {
+			continue
+		}
+
+		var list []AnnotationInfo
+		for _, o := range annots {
+			if a, ok := annotationInfo(ctx, o); ok && req.matches(a) {
+				list = append(list, a)
+			}
+		}
+		if len(list) > 0 {
+			result = append(result, PageAnnotations{Page: pageNr, Annotations: list})
+		}
+	}
+	return result, nil
+}
+
+// annotationInfo describes the annotation o. It reports false for anything
+// that is not a listable annotation, including form widgets and popups.
+func annotationInfo(ctx *model.Context, o types.Object) (AnnotationInfo, bool) {
+	d, err := ctx.DereferenceDict(o)
+	if err != nil || d == nil {
+		return AnnotationInfo{}, false
+	}
+	st := d.Subtype()
+	if st == nil || *st == "Widget" || *st == "Popup" {
+		return AnnotationInfo{}, false
+	}
+
+	a := AnnotationInfo{Type: *st}
+	if ir, ok := o.(types.IndirectRef); ok {
+		a.ObjNr = ir.ObjectNumber.Value()
+	}
+	a.Author, _ = ctx.DereferenceStringOrHexLiteral(d["T"], model.V10, nil)
+	a.Contents, _ = ctx.DereferenceStringOrHexLiteral(d["Contents"], model.V10, nil)
+	if rect, err := ctx.DereferenceArray(d["Rect"]); err == nil && len(rect) == 4 {
+		for i := range rect {
+			a.Rect[i], _ = ctx.DereferenceNumber(rect[i])
+		}
+	}
+	return a, true
+}
+
+// removeAnnotations deletes the annotations collectAnnotations would list,
+// along with their popups, by rewriting the Annots array of each page. That
+// also reaches annotations stored directly in the array, which have no
+// object number to remove them by. It returns how many were removed.
+func removeAnnotations(ctx *model.Context, req AnnotationsRequest) (int, error) {
+	pages, err := req.Pages.PagesOrAll(ctx.PageCount)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, pageNr := range pages {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil {
+			continue
+		}
+		annots, err := ctx.DereferenceArray(pageDict["Annots"])
+		if err != nil || len(annots) == 0 {
+			continue
+		}
+
+		drop := make([]bool, len(annots))
+		removed := map[int]bool{}
+		popups := map[int]bool{}
+		for i, o := range annots {
+			a, ok := annotationInfo(ctx, o)
+			if !ok || !req.matches(a) {
+				continue
+			}
+			drop[i] = true
+			count++
+			if a.ObjNr > 0 {
+				removed[a.ObjNr] = true
+			}
+			if d, err := ctx.DereferenceDict(o); err == nil {
+				if ir, ok := d["Popup"].(types.IndirectRef); ok {
+					popups[ir.ObjectNumber.Value()] = true
+				}
+			}
+		}
+
+		var kept types.Array
+		for i, o := range annots {
+			if drop[i] {
+				continue
+			}
+			if ir, ok := o.(types.IndirectRef); ok && popups[ir.ObjectNumber.Value()] {
+				continue
+			}
+			if d, err := ctx.DereferenceDict(o); err == nil && d != nil {
+				if st := d.Subtype(); st != nil && *st == "Popup" {
+					if ir, ok := d["Parent"].(types.IndirectRef); ok && removed[ir.ObjectNumber.Value()] {
+						continue
+					}
+				}
+			}
+			kept = append(kept, o)
+		}
+		if len(kept) == len(annots) {
+			continue
+		}
+		if len(kept) == 0 {
+			pageDict.Delete("Annots")
+		} else {
+			pageDict["Annots"] = kept
+		}
+	}
+	return count, nil
+}
+
+func AnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[AnnotationsHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req AnnotationsRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+	if req.Mode != "list" && req.Mode != "remove" && req.Mode != "flatten" {
+		jsonError(w, "Invalid mode. Use 'list', 'remove' or 'flatten'", http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "flatten" && (len(req.Types) > 0 || len(req.Authors) > 0 || !req.Pages.IsEmpty()) {
+		jsonError(w, "Filters are not supported with 'flatten'; all annotations are flattened", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "annotations-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	matched, err := collectAnnotations(ctx, req)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count := 0
+	for _, p := range matched {
+		count += len(p.Annotations)
+	}
+	fmt.Printf("[AnnotationsHandler] 📄 %d matching annotation(s)\n", count)
+
+	if req.Mode == "list" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pages": matched,
+			"count": count,
+		})
+		fmt.Println("[AnnotationsHandler] ✅ Done in", time.Since(start))
+		return
+	}
+
+	outputPath := inputPath + "-annotations.pdf"
+	defer os.Remove(outputPath)
+
+	switch req.Mode {
+	case "remove":
+		if count == 0 {
+			jsonError(w, "No matching annotations to remove", http.StatusBadRequest)
+			return
+		}
+		if count, err = removeAnnotations(ctx, req); err == nil {
+			err = api.WriteContextFile(ctx, outputPath)
+		}
+
+	case "flatten":
+		// qpdf treats form widgets like any other annotation, so filled-in
+		// fields end up as page content and are no longer editable.
+		_, err = utils.RunQPDF(r.Context(), "--generate-appearances", "--flatten-annotations=all", inputPath, outputPath)
+	}
+	if err != nil {
+		fmt.Println("[AnnotationsHandler] ❌ Failed:", err)
+		jsonError(w, fmt.Sprintf("Failed to %s annotations: %v", req.Mode, err), http.StatusInternalServerError)
+		return
+	}
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("annotations/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":      url,
+		"affected": count,
+	})
+
+	fmt.Println("[AnnotationsHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,766 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// AttachmentSpec describes one uploaded file to embed. Specs are matched
+// to the 'attachments' uploads by position.
+type AttachmentSpec struct {
+	Name         string `json:"name"`         // default: the uploaded file name
+	Description  string `json:"description"`  // shown by viewers next to the file
+	MimeType     string `json:"mimeType"`     // default: guessed from the extension
+	Relationship string `json:"relationship"` // AFRelationship, default "Unspecified"
+}
+
+// AttachmentInfo is an embedded file as reported by list and returned
+// after every change.
+type AttachmentInfo struct {
+	Name         string     `json:"name"`
+	Description  string     `json:"description,omitempty"`
+	MimeType     string     `json:"mimeType,omitempty"`
+	Relationship string     `json:"relationship,omitempty"`
+	Size         int        `json:"size"`
+	Modified     *time.Time `json:"modified,omitempty"`
+}
+
+type AttachmentsRequest struct {
+	Mode         string           `json:"mode"`         // "list", "add", "extract", "remove" or "facturx"
+	Files        []AttachmentSpec `json:"files"`        // add: options for the uploaded files
+	Names        []string         `json:"names"`        // extract (default all) and remove: attachment names
+	Relationship string           `json:"relationship"` // facturx: AFRelationship of the invoice XML, default "Data"
+	Profile      string           `json:"profile"`      // facturx: conformance level, detected from the XML when empty
+}
+
+// afRelationships are the AFRelationship values of PDF 2.0 and PDF/A-3.
+var afRelationships = map[string]bool{
+	"Source": true, "Data": true, "Alternative": true, "Supplement": true,
+	"EncryptedPayload": true, "FormData": true, "Schema": true, "Unspecified": true,
+}
+
+// facturXProfiles maps the conformance levels of Factur-X / ZUGFeRD 2 to
+// the name the invoice XML must be embedded under.
+var facturXProfiles = map[string]string{
+	"MINIMUM":   "factur-x.xml",
+	"BASIC WL":  "factur-x.xml",
+	"BASIC":     "factur-x.xml",
+	"EN 16931":  "factur-x.xml",
+	"EXTENDED":  "factur-x.xml",
+	"XRECHNUNG": "xrechnung.xml",
+}
+
+var facturXGuidelinePattern = regexp.MustCompile(`(?s)GuidelineSpecifiedDocumentContextParameter>.*?<(?:\w+:)?ID>\s*([^<]+?)\s*<`)
+
+// embeddedFile is an entry of the EmbeddedFiles name tree.
+type embeddedFile struct {
+	info   AttachmentInfo
+	id     string
+	objNr  int // object number of the file specification, 0 if direct
+	stream *types.StreamDict
+}
+
+func listEmbeddedFiles(ctx *model.Context) ([]embeddedFile, error) {
+	xRefTable := ctx.XRefTable
+	if err := xRefTable.LocateNameTree("EmbeddedFiles", false); err != nil {
+		return nil, err
+	}
+	tree := xRefTable.Names["EmbeddedFiles"]
+	if tree == nil {
+		return nil, nil
+	}
+
+	var files []embeddedFile
+	err := tree.Process(xRefTable, func(xRefTable *model.XRefTable, id string, o *types.Object) error {
+		spec, err := xRefTable.DereferenceDict(*o)
+		if err != nil || spec == nil {
+			return fmt.Errorf("attachment %q: invalid file specification", id)
+		}
+		f := embeddedFile{id: id, info: AttachmentInfo{Name: id}}
+		if ir, ok := (*o).(types.IndirectRef); ok {
+			f.objNr = ir.ObjectNumber.Value()
+		}
+		for _, key := range []string{"UF", "F"} {
+			if s, err := xRefTable.DereferenceStringOrHexLiteral(spec[key], model.V10, nil); err == nil && s != "" {
+				f.info.Name = s
+				break
+			}
+		}
+		if s, err := xRefTable.DereferenceStringOrHexLiteral(spec["Desc"], model.V10, nil); err == nil {
+			f.info.Description = s
+		}
+		if n := spec.NameEntry("AFRelationship"); n != nil {
+			f.info.Relationship = *n
+		}
+
+		ef, err := xRefTable.DereferenceDict(spec["EF"])
+		if err != nil || ef == nil {
+			files = append(files, f)
+			return nil
+		}
+		sd, _, err := xRefTable.DereferenceStreamDict(ef["F"])
+		if err != nil || sd == nil {
+			files = append(files, f)
+			return nil
+		}
+		f.stream = sd
+		if n := sd.NameEntry("Subtype"); n != nil {
+			f.info.MimeType = *n
+		}
+		if params, err := xRefTable.DereferenceDict(sd.Dict["Params"]); err == nil && params != nil {
+			if size := params.IntEntry("Size"); size != nil {
+				f.info.Size = *size
+			}
+			if s, err := xRefTable.DereferenceStringOrHexLiteral(params["ModDate"], model.V10, nil); err == nil {
+				if t, ok := types.DateTime(s, true); ok {
+					f.info.Modified = &t
+				}
+			}
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+func attachmentInfos(files []embeddedFile) []AttachmentInfo {
+	infos := make([]AttachmentInfo, 0, len(files))
+	for _, f := range files {
+		infos = append(infos, f.info)
+	}
+	return infos
+}
+
+// embeddedFileData returns the decoded contents of f.
+func embeddedFileData(f embeddedFile) ([]byte, error) {
+	if f.stream == nil {
+		return nil, fmt.Errorf("attachment %q has no embedded data", f.info.Name)
+	}
+	if f.stream.FilterPipeline == nil {
+		return f.stream.Raw, nil
+	}
+	if err := f.stream.Decode(); err != nil {
+		return nil, fmt.Errorf("attachment %q cannot be decoded: %v", f.info.Name, err)
+	}
+	return f.stream.Content, nil
+}
+
+// removeEmbeddedFiles deletes the named attachments and drops them from
+// the catalog's associated files. It returns the names it did not find.
+func removeEmbeddedFiles(ctx *model.Context, names []string) ([]string, error) {
+	files, err := listEmbeddedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids, missing []string
+	objNrs := map[int]bool{}
+	for _, name := range names {
+		found := false
+		for _, f := range files {
+			if f.info.Name == name || f.id == name {
+				ids = append(ids, f.id)
+				objNrs[f.objNr] = true
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(ids) == 0 {
+		return missing, nil
+	}
+	if _, err := ctx.RemoveAttachments(ids); err != nil {
+		return nil, err
+	}
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	if af, err := ctx.DereferenceArray(root["AF"]); err == nil && af != nil {
+		kept := types.Array{}
+		for _, o := range af {
+			if ir, ok := o.(types.IndirectRef); ok && objNrs[ir.ObjectNumber.Value()] {
+				continue
+			}
+			kept = append(kept, o)
+		}
+		if len(kept) == 0 {
+			delete(root, "AF")
+		} else {
+			root["AF"] = kept
+		}
+	}
+	return missing, nil
+}
+
+// addEmbeddedFile embeds data under name, replacing an attachment of the
+// same name, and lists it among the document's associated files so that
+// PDF/A-3 validators accept it.
+func addEmbeddedFile(ctx *model.Context, spec AttachmentSpec, data []byte) error {
+	if _, err := removeEmbeddedFiles(ctx, []string{spec.Name}); err != nil {
+		return err
+	}
+	xRefTable := ctx.XRefTable
+	if err := xRefTable.LocateNameTree("EmbeddedFiles", true); err != nil {
+		return err
+	}
+
+	sd, err := xRefTable.NewStreamDictForBuf(data)
+	if err != nil {
+		return err
+	}
+	sd.InsertName("Type", "EmbeddedFile")
+	if spec.MimeType != "" {
+		sd.InsertName("Subtype", spec.MimeType)
+	}
+	sum := md5.Sum(data)
+	params := types.NewDict()
+	params.InsertInt("Size", len(data))
+	params.Insert("ModDate", types.StringLiteral(types.DateString(time.Now())))
+	params.Insert("CheckSum", types.NewHexLiteral(sum[:]))
+	sd.Insert("Params", params)
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	streamRef, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+
+	fileSpec, err := xRefTable.NewFileSpecDict(spec.Name, spec.Name, spec.Description, *streamRef)
+	if err != nil {
+		return err
+	}
+	fileSpec.InsertName("AFRelationship", spec.Relationship)
+	specRef, err := xRefTable.IndRefForNewObject(fileSpec)
+	if err != nil {
+		return err
+	}
+	m := model.NameMap{spec.Name: []types.Dict{fileSpec}}
+	if err := xRefTable.Names["EmbeddedFiles"].Add(xRefTable, spec.Name, *specRef, m, []string{"F", "UF"}); err != nil {
+		return err
+	}
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	af, _ := ctx.DereferenceArray(root["AF"])
+	root["AF"] = append(af, *specRef)
+	return nil
+}
+
+// detectFacturXProfile reads the conformance level from the guideline ID
+// of a CrossIndustryInvoice, e.g. urn:cen.eu:en16931:2017 for EN 16931.
+func detectFacturXProfile(invoice []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(invoice))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", fmt.Errorf("invoice is not valid XML: %v", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			if se.Name.Local != "CrossIndustryInvoice" {
+				return "", fmt.Errorf("expected a CrossIndustryInvoice document, got <%s>", se.Name.Local)
+			}
+			break
+		}
+	}
+
+	m := facturXGuidelinePattern.FindSubmatch(invoice)
+	if m == nil {
+		return "", fmt.Errorf("invoice has no guideline ID; set 'profile'")
+	}
+	id := strings.ToLower(string(m[1]))
+	switch {
+	case strings.Contains(id, "minimum"):
+		return "MINIMUM", nil
+	case strings.Contains(id, "basicwl"):
+		return "BASIC WL", nil
+	case strings.Contains(id, "basic"):
+		return "BASIC", nil
+	case strings.Contains(id, "extended"):
+		return "EXTENDED", nil
+	case strings.Contains(id, "xrechnung"):
+		return "XRECHNUNG", nil
+	case strings.Contains(id, "en16931"):
+		return "EN 16931", nil
+	}
+	return "", fmt.Errorf("unknown guideline ID %q; set 'profile'", m[1])
+}
+
+const facturXNamespace = "urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#"
+
+var (
+	xmpFacturXDescriptionPattern = regexp.MustCompile(`(?s)[ \t]*<rdf:Description\b[^>]*xmlns:fx="urn:factur-x:[^"]*"[^>]*?(?:/>|>.*?</rdf:Description>)\n?`)
+	xmpDatePattern               = regexp.MustCompile(`((?:<|\s)xmp:(ModifyDate|MetadataDate)(?:>|\s*=\s*["']))[^<"']*`)
+)
+
+// facturXSchema is the PDF/A extension schema entry declaring the fx
+// properties. It declares its own namespaces so that it can be added to an
+// existing schema list.
+func facturXSchema() string {
+	property := func(name, desc string) string {
+		return fmt.Sprintf(`
+       <rdf:li rdf:parseType="Resource">
+        <pdfaProperty:name>%s</pdfaProperty:name>
+        <pdfaProperty:valueType>Text</pdfaProperty:valueType>
+        <pdfaProperty:category>external</pdfaProperty:category>
+        <pdfaProperty:description>%s</pdfaProperty:description>
+       </rdf:li>`, name, desc)
+	}
+	return fmt.Sprintf(`
+     <rdf:li rdf:parseType="Resource"
+       xmlns:pdfaSchema="http://www.aiim.org/pdfa/ns/schema#"
+       xmlns:pdfaProperty="http://www.aiim.org/pdfa/ns/property#">
+      <pdfaSchema:schema>Factur-X PDFA Extension Schema</pdfaSchema:schema>
+      <pdfaSchema:namespaceURI>%s</pdfaSchema:namespaceURI>
+      <pdfaSchema:prefix>fx</pdfaSchema:prefix>
+      <pdfaSchema:property>
+       <rdf:Seq>%s%s%s%s
+       </rdf:Seq>
+      </pdfaSchema:property>
+     </rdf:li>
+    `,
+		facturXNamespace,
+		property("DocumentFileName", "The name of the embedded XML document"),
+		property("DocumentType", "The type of the hybrid document in capital letters, e.g. INVOICE or ORDER"),
+		property("Version", "The actual version of the standard applying to the embedded XML document"),
+		property("ConformanceLevel", "The conformance level of the embedded XML document"),
+	)
+}
+
+// mergeFacturXMetadata adds the properties Factur-X requires to an existing
+// PDF/A-3 XMP packet: the fx properties naming the invoice and the
+// extension schema that declares them. Everything else in the packet is
+// kept, apart from the modification dates, which are brought up to date.
+func mergeFacturXMetadata(xmp, fileName, profile string, now time.Time) (string, error) {
+	if !strings.Contains(xmp, "</rdf:RDF>") {
+		return "", fmt.Errorf("existing XMP metadata has no rdf:RDF element")
+	}
+	// Properties from an earlier run are replaced rather than repeated.
+	xmp = xmpFacturXDescriptionPattern.ReplaceAllString(xmp, "")
+
+	date := now.Format(time.RFC3339)
+	dated := map[string]bool{}
+	xmp = xmpDatePattern.ReplaceAllStringFunc(xmp, func(m string) string {
+		sub := xmpDatePattern.FindStringSubmatch(m)
+		dated[sub[2]] = true
+		return sub[1] + date
+	})
+
+	var add strings.Builder
+	if !dated["ModifyDate"] || !dated["MetadataDate"] {
+		add.WriteString(`  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/">` + "\n")
+		for _, prop := range []string{"ModifyDate", "MetadataDate"} {
+			if !dated[prop] {
+				fmt.Fprintf(&add, "   <xmp:%s>%s</xmp:%s>\n", prop, date, prop)
+			}
+		}
+		add.WriteString("  </rdf:Description>\n")
+	}
+	fmt.Fprintf(&add, `  <rdf:Description rdf:about="" xmlns:fx="%s">
+   <fx:DocumentType>INVOICE</fx:DocumentType>
+   <fx:DocumentFileName>%s</fx:DocumentFileName>
+   <fx:Version>1.0</fx:Version>
+   <fx:ConformanceLevel>%s</fx:ConformanceLevel>
+  </rdf:Description>
+`, facturXNamespace, html.EscapeString(fileName), html.EscapeString(profile))
+
+	// The schema list is a single property, so the Factur-X schema joins an
+	// existing list instead of starting a second one.
+	switch end := strings.Index(xmp, "</pdfaExtension:schemas>"); {
+	case strings.Contains(xmp, "<pdfaSchema:namespaceURI>"+facturXNamespace):
+	case end >= 0:
+		bag := strings.LastIndex(xmp[:end], "</rdf:Bag>")
+		if bag < 0 {
+			return "", fmt.Errorf("existing PDF/A extension schemas are not a list")
+		}
+		xmp = xmp[:bag] + facturXSchema() + xmp[bag:]
+	default:
+		add.WriteString(`  <rdf:Description rdf:about="" xmlns:pdfaExtension="http://www.aiim.org/pdfa/ns/extension/">
+   <pdfaExtension:schemas>
+    <rdf:Bag>` + facturXSchema() + `</rdf:Bag>
+   </pdfaExtension:schemas>
+  </rdf:Description>
+`)
+	}
+
+	// Insert on lines of their own, just before the closing rdf:RDF tag.
+	i := strings.LastIndex(xmp, "</rdf:RDF>")
+	if line := strings.LastIndex(xmp[:i], "\n") + 1; strings.TrimSpace(xmp[line:i]) == "" {
+		return xmp[:line] + add.String() + xmp[line:], nil
+	}
+	return xmp[:i] + "\n" + add.String() + xmp[i:], nil
+}
+
+// setXMPMetadata replaces the catalog's metadata stream. XMP is stored
+// uncompressed so that tools can find it without decoding the file.
+func setXMPMetadata(ctx *model.Context, xmp string) error {
+	sd := types.StreamDict{Dict: types.NewDict(), Content: []byte(xmp)}
+	sd.InsertName("Type", "Metadata")
+	sd.InsertName("Subtype", "XML")
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	ir, err := ctx.IndRefForNewObject(sd)
+	if err != nil {
+		return err
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	root["Metadata"] = *ir
+	return nil
+}
+
+// existingPDFA3Metadata returns the XMP packet of a document claiming
+// PDF/A-3 together with its conformance letter, or an empty conformance
+// when the document makes no such claim.
+func existingPDFA3Metadata(ctx *model.Context) (string, string) {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return "", ""
+	}
+	sd, _, err := ctx.DereferenceStreamDict(root["Metadata"])
+	if err != nil || sd == nil || sd.Decode() != nil {
+		return "", ""
+	}
+	part := xmpPartPattern.FindSubmatch(sd.Content)
+	conf := xmpConformancePattern.FindSubmatch(sd.Content)
+	if part == nil || conf == nil || string(part[1]) != "3" {
+		return "", ""
+	}
+	return string(sd.Content), strings.ToUpper(string(conf[1]))
+}
+
+// uniqueFilename returns name, or fallback when name is empty, with a
+// numeric suffix before the extension if it is already used. The result is
+// recorded in used.
+func uniqueFilename(name, fallback string, used map[string]bool) string {
+	if name == "" {
+		name = fallback
+	}
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 2; used[candidate]; n++ {
+		candidate = stem + "-" + strconv.Itoa(n) + ext
+	}
+	used[candidate] = true
+	return candidate
+}
+
+func readUpload(r *http.Request, field string, i int) (string, []byte, error) {
+	fh := r.MultipartForm.File[field][i]
+	f, err := fh.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return fh.Filename, data, err
+}
+
+func AttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[AttachmentsHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req AttachmentsRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Mode {
+	case "list", "extract":
+	case "add":
+		uploads := len(r.MultipartForm.File["attachments"])
+		if uploads == 0 {
+			jsonError(w, "Missing 'attachments' field", http.StatusBadRequest)
+			return
+		}
+		if len(req.Files) > uploads {
+			jsonError(w, fmt.Sprintf("'files' describes %d attachments but %d were uploaded", len(req.Files), uploads), http.StatusBadRequest)
+			return
+		}
+		for _, spec := range req.Files {
+			if spec.Relationship != "" && !afRelationships[spec.Relationship] {
+				jsonError(w, fmt.Sprintf("Invalid relationship %q", spec.Relationship), http.StatusBadRequest)
+				return
+			}
+		}
+	case "remove":
+		if len(req.Names) == 0 {
+			jsonError(w, "'names' is required for remove", http.StatusBadRequest)
+			return
+		}
+	case "facturx":
+		if len(r.MultipartForm.File["xml"]) == 0 {
+			jsonError(w, "Missing 'xml' field", http.StatusBadRequest)
+			return
+		}
+		if req.Relationship == "" {
+			req.Relationship = "Data"
+		}
+		if !afRelationships[req.Relationship] {
+			jsonError(w, fmt.Sprintf("Invalid relationship %q", req.Relationship), http.StatusBadRequest)
+			return
+		}
+		if _, ok := facturXProfiles[req.Profile]; req.Profile != "" && !ok {
+			jsonError(w, "Invalid profile. Use 'MINIMUM', 'BASIC WL', 'BASIC', 'EN 16931', 'EXTENDED' or 'XRECHNUNG'", http.StatusBadRequest)
+			return
+		}
+	default:
+		jsonError(w, "Invalid mode. Use 'list', 'add', 'extract', 'remove' or 'facturx'", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "attachments-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	files, err := listEmbeddedFiles(ctx)
+	if err != nil {
+		jsonError(w, "Failed to read attachments: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	switch req.Mode {
+	case "list":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"attachments": attachmentInfos(files),
+		})
+		fmt.Println("[AttachmentsHandler] ✅ Done in", time.Since(start))
+		return
+
+	case "extract":
+		wanted := map[string]bool{}
+		for _, n := range req.Names {
+			wanted[n] = true
+		}
+		// Match the name or the name tree key, as remove does, and make sure
+		// every name is there before anything is uploaded.
+		var selected []embeddedFile
+		found := map[string]bool{}
+		for _, f := range files {
+			if len(wanted) > 0 && !wanted[f.info.Name] && !wanted[f.id] {
+				continue
+			}
+			found[f.info.Name], found[f.id] = true, true
+			selected = append(selected, f)
+		}
+		for _, n := range req.Names {
+			if !found[n] {
+				jsonError(w, fmt.Sprintf("No attachment named %q", n), http.StatusNotFound)
+				return
+			}
+		}
+
+		batch := time.Now().UnixNano()
+		uploads := []FileUpload{}
+		used := map[string]bool{}
+		for _, f := range selected {
+			data, err := embeddedFileData(f)
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			name := uniqueFilename(safeFilename(f.info.Name), "attachment", used)
+			contentType := f.info.MimeType
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			url, err := utils.UploadStreamToR2WithType(fmt.Sprintf("attachments/%d/%s", batch, name), bytes.NewReader(data), contentType)
+			if err != nil {
+				jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+				return
+			}
+			uploads = append(uploads, FileUpload{Filename: f.info.Name, URL: url})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"files": uploads,
+		})
+		fmt.Println("[AttachmentsHandler] ✅ Done in", time.Since(start))
+		return
+
+	case "remove":
+		missing, err := removeEmbeddedFiles(ctx, req.Names)
+		if err != nil {
+			jsonError(w, "Failed to remove attachments: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(missing) > 0 {
+			jsonError(w, fmt.Sprintf("No attachment named %q", missing[0]), http.StatusNotFound)
+			return
+		}
+
+	case "add":
+		for i := range r.MultipartForm.File["attachments"] {
+			filename, data, err := readUpload(r, "attachments", i)
+			if err != nil {
+				jsonError(w, "Unable to read uploaded attachment", http.StatusBadRequest)
+				return
+			}
+			var spec AttachmentSpec
+			if i < len(req.Files) {
+				spec = req.Files[i]
+			}
+			if spec.Name == "" {
+				spec.Name = filepath.Base(filename)
+			}
+			if spec.MimeType == "" {
+				spec.MimeType, _, _ = strings.Cut(mime.TypeByExtension(filepath.Ext(spec.Name)), ";")
+			}
+			if spec.Relationship == "" {
+				spec.Relationship = "Unspecified"
+			}
+			if err := addEmbeddedFile(ctx, spec, data); err != nil {
+				jsonError(w, fmt.Sprintf("Failed to attach %q: %s", spec.Name, err.Error()), http.StatusInternalServerError)
+				return
+			}
+		}
+
+	case "facturx":
+		// Factur-X is a PDF/A-3 profile. Attaching an invoice does not make a
+		// document conform, so only documents that already do are accepted.
+		xmp, conformance := existingPDFA3Metadata(ctx)
+		if conformance == "" {
+			jsonError(w, "Factur-X needs a PDF/A-3 document; convert it with /pdfa/convert first", http.StatusUnprocessableEntity)
+			return
+		}
+		if root, err := ctx.Catalog(); err != nil || !hasPDFAOutputIntent(ctx.XRefTable, root) {
+			jsonError(w, "Factur-X needs a PDF/A output intent with an embedded ICC profile; convert the document with /pdfa/convert first", http.StatusUnprocessableEntity)
+			return
+		}
+
+		_, invoice, err := readUpload(r, "xml", 0)
+		if err != nil {
+			jsonError(w, "Unable to read uploaded invoice XML", http.StatusBadRequest)
+			return
+		}
+		detected, err := detectFacturXProfile(invoice)
+		if err != nil && req.Profile == "" {
+			jsonError(w, "Invalid invoice: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Profile == "" {
+			req.Profile = detected
+		}
+		fileName := facturXProfiles[req.Profile]
+
+		// Drop invoices attached by an earlier run under either name.
+		if _, err := removeEmbeddedFiles(ctx, []string{"factur-x.xml", "xrechnung.xml", "zugferd-invoice.xml"}); err != nil {
+			jsonError(w, "Failed to remove previous invoice: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		spec := AttachmentSpec{
+			Name:         fileName,
+			Description:  "Factur-X invoice",
+			MimeType:     "text/xml",
+			Relationship: req.Relationship,
+		}
+		if err := addEmbeddedFile(ctx, spec, invoice); err != nil {
+			jsonError(w, "Failed to attach invoice: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		merged, err := mergeFacturXMetadata(xmp, fileName, req.Profile, time.Now())
+		if err != nil {
+			jsonError(w, "Failed to update XMP metadata: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err := setXMPMetadata(ctx, merged); err != nil {
+			jsonError(w, "Failed to write XMP metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Printf("[AttachmentsHandler] ✅ Attached %s (%s) to a PDF/A-3%s document\n", fileName, req.Profile, strings.ToLower(conformance))
+	}
+
+	outputPath := inputPath + "-attachments.pdf"
+	defer os.Remove(outputPath)
+
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		fmt.Println("[AttachmentsHandler] ❌ Failed to write PDF:", err)
+		jsonError(w, "Failed to write PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Report the attachments as they are in the written file.
+	written, err := api.ReadContextFile(outputPath)
+	if err == nil {
+		files, err = listEmbeddedFiles(written)
+	}
+	if err != nil {
+		jsonError(w, "Failed to read written attachments: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("attachments/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":         url,
+		"attachments": attachmentInfos(files),
+	})
+
+	fmt.Println("[AttachmentsHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUniqueFilename(t *testing.T) {
+	used := map[string]bool{}
+	tests := []struct {
+		name, fallback, want string
+	}{
+		{"a.xml", "attachment", "a.xml"},
+		{"a.xml", "attachment", "a-2.xml"},
+		{"a-2.xml", "attachment", "a-2-2.xml"},
+		{"a.xml", "attachment", "a-3.xml"},
+		{"", "attachment", "attachment"},
+		{"", "attachment", "attachment-2"},
+		{"archive.tar.gz", "", "archive.tar.gz"},
+		{"archive.tar.gz", "", "archive.tar-2.gz"},
+	}
+	for _, tt := range tests {
+		if got := uniqueFilename(tt.name, tt.fallback, used); got != tt.want {
+			t.Errorf("uniqueFilename(%q, %q) = %q, want %q", tt.name, tt.fallback, got, tt.want)
+		}
+	}
+}
+
+func facturXInvoice(guideline string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rsm:CrossIndustryInvoice xmlns:rsm="urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100"
+  xmlns:ram="urn:un:unece:uncefact:data:standard:ReusableAggregateBusinessInformationEntity:100">
+ <rsm:ExchangedDocumentContext>
+  <ram:GuidelineSpecifiedDocumentContextParameter>
+   <ram:ID>` + guideline + `</ram:ID>
+  </ram:GuidelineSpecifiedDocumentContextParameter>
+ </rsm:ExchangedDocumentContext>
+</rsm:CrossIndustryInvoice>`)
+}
+
+func TestDetectFacturXProfile(t *testing.T) {
+	tests := []struct {
+		guideline string
+		want      string
+	}{
+		{"urn:factur-x.eu:1p0:minimum", "MINIMUM"},
+		{"urn:factur-x.eu:1p0:basicwl", "BASIC WL"},
+		{"urn:cen.eu:en16931:2017#compliant#urn:factur-x.eu:1p0:basic", "BASIC"},
+		{"urn:cen.eu:en16931:2017", "EN 16931"},
+		{"urn:cen.eu:en16931:2017#conformant#urn:factur-x.eu:1p0:extended", "EXTENDED"},
+		{"urn:cen.eu:en16931:2017#compliant#urn:xeinkauf.de:kosit:xrechnung_3.0", "XRECHNUNG"},
+	}
+	for _, tt := range tests {
+		got, err := detectFacturXProfile(facturXInvoice(tt.guideline))
+		if err != nil {
+			t.Errorf("detectFacturXProfile(%q): unexpected error: %v", tt.guideline, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("detectFacturXProfile(%q) = %q, want %q", tt.guideline, got, tt.want)
+		}
+	}
+
+	for _, invoice := range []string{
+		"not xml",
+		`<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"/>`,
+		`<rsm:CrossIndustryInvoice xmlns:rsm="urn:x"/>`,
+		string(facturXInvoice("urn:example:unknown")),
+	} {
+		if _, err := detectFacturXProfile([]byte(invoice)); err == nil {
+			t.Errorf("detectFacturXProfile(%q): want error", invoice)
+		}
+	}
+}
+
+const pdfa3XMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/">
+   <pdfaid:part>3</pdfaid:part>
+   <pdfaid:conformance>B</pdfaid:conformance>
+  </rdf:Description>
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+   <xmp:ModifyDate>2020-01-01T00:00:00Z</xmp:ModifyDate>
+  </rdf:Description>
+  <rdf:Description rdf:about="" xmlns:pdfaExtension="http://www.aiim.org/pdfa/ns/extension/">
+   <pdfaExtension:schemas>
+    <rdf:Bag>
+     <rdf:li rdf:parseType="Resource">
+      <pdfaSchema:namespaceURI>urn:example:other#</pdfaSchema:namespaceURI>
+     </rdf:li>
+    </rdf:Bag>
+   </pdfaExtension:schemas>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestMergeFacturXMetadata(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	xmp, err := mergeFacturXMetadata(pdfa3XMP, "factur-x.xml", "EN 16931", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Running it again must replace the Factur-X properties, not add more.
+	again, err := mergeFacturXMetadata(xmp, "factur-x.xml", "BASIC", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		xmp, substr string
+		want        int
+	}{
+		{xmp, "<pdfaExtension:schemas>", 1},
+		{xmp, "<rdf:Bag>", 1},
+		{xmp, "<pdfaSchema:namespaceURI>urn:example:other#", 1},
+		{xmp, "<pdfaSchema:namespaceURI>" + facturXNamespace, 1},
+		{xmp, "<fx:ConformanceLevel>EN 16931</fx:ConformanceLevel>", 1},
+		{xmp, "<xmp:ModifyDate>2026-03-01T12:00:00Z</xmp:ModifyDate>", 1},
+		{xmp, "<xmp:MetadataDate>2026-03-01T12:00:00Z</xmp:MetadataDate>", 1},
+		{xmp, "<pdfaid:part>3</pdfaid:part>", 1},
+		{again, "<pdfaSchema:namespaceURI>" + facturXNamespace, 1},
+		{again, "<fx:DocumentType>", 1},
+		{again, "<fx:ConformanceLevel>BASIC</fx:ConformanceLevel>", 1},
+		{again, "<xmp:ModifyDate>2026-03-01T13:00:00Z</xmp:ModifyDate>", 1},
+		{again, "<xmp:MetadataDate>", 1},
+	} {
+		if got := strings.Count(tt.xmp, tt.substr); got != tt.want {
+			t.Errorf("%q appears %d times, want %d in:\n%s", tt.substr, got, tt.want, tt.xmp)
+		}
+	}
+	if !strings.HasSuffix(strings.TrimSpace(xmp[:strings.Index(xmp, "</rdf:RDF>")]), "</rdf:Description>") {
+		t.Errorf("properties not added inside rdf:RDF:\n%s", xmp)
+	}
+
+	for _, bad := range []string{
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`,
+		strings.Replace(strings.Replace(pdfa3XMP, "<rdf:Bag>", "<rdf:Seq>", 1), "</rdf:Bag>", "</rdf:Seq>", 1),
+	} {
+		if _, err := mergeFacturXMetadata(bad, "factur-x.xml", "BASIC", now); err == nil {
+			t.Errorf("mergeFacturXMetadata(%q): want error", bad)
+		}
+	}
+}
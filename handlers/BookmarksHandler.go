@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// BookmarkNode is one outline entry. Children must start on or after their
+// parent's page and siblings must be in page order.
+type BookmarkNode struct {
+	Title    string         `json:"title"`
+	Page     int            `json:"page"`
+	Bold     bool           `json:"bold,omitempty"`
+	Italic   bool           `json:"italic,omitempty"`
+	Children []BookmarkNode `json:"children,omitempty"`
+}
+
+type BookmarksRequest struct {
+	Mode      string         `json:"mode"`      // "export", "replace" or "generate"
+	Bookmarks []BookmarkNode `json:"bookmarks"` // replace: the new outline
+	Source    string         `json:"source"`    // generate: "headings" or "pagemap"
+	PageMap   map[int]string `json:"pageMap"`   // generate from pagemap: page number -> title
+	MaxLevel  int            `json:"maxLevel"`  // generate from headings: outline depth, default 2
+}
+
+const (
+	defaultHeadingLevels = 2
+	headingMinRatio      = 1.15 // font size relative to body text to count as a heading
+	headingMaxLength     = 120  // longer lines are paragraphs set in a large font
+)
+
+func bookmarksFromPDFCPU(bms []pdfcpu.Bookmark) []BookmarkNode {
+	nodes := make([]BookmarkNode, 0, len(bms))
+	for _, bm := range bms {
+		nodes = append(nodes, BookmarkNode{
+			Title:    bm.Title,
+			Page:     bm.PageFrom,
+			Bold:     bm.Bold,
+			Italic:   bm.Italic,
+			Children: bookmarksFromPDFCPU(bm.Kids),
+		})
+	}
+	return nodes
+}
+
+func bookmarksToPDFCPU(nodes []BookmarkNode) []pdfcpu.Bookmark {
+	bms := make([]pdfcpu.Bookmark, 0, len(nodes))
+	for _, n := range nodes {
+		bms = append(bms, pdfcpu.Bookmark{
+			Title:    n.Title,
+			PageFrom: n.Page,
+			Bold:     n.Bold,
+			Italic:   n.Italic,
+			Kids:     bookmarksToPDFCPU(n.Children),
+		})
+	}
+	return bms
+}
+
+// validateBookmarks checks the ordering rules pdfcpu enforces when writing
+// an outline, so callers get an error naming the offending entry.
+func validateBookmarks(nodes []BookmarkNode, parentPage, pageCount int) error {
+	prev := parentPage
+	for _, n := range nodes {
+		if strings.TrimSpace(n.Title) == "" {
+			return fmt.Errorf("bookmark on page %d has an empty title", n.Page)
+		}
+		if n.Page < 1 || n.Page > pageCount {
+			return fmt.Errorf("bookmark %q: page %d out of range (1-%d)", n.Title, n.Page, pageCount)
+		}
+		if n.Page < prev {
+			return fmt.Errorf("bookmark %q: page %d comes before the preceding entry or its parent (page %d)", n.Title, n.Page, prev)
+		}
+		if err := validateBookmarks(n.Children, n.Page, pageCount); err != nil {
+			return err
+		}
+		prev = n.Page
+	}
+	return nil
+}
+
+// offsetBookmarks shifts every page in the tree by delta.
+func offsetBookmarks(nodes []BookmarkNode, delta int) []BookmarkNode {
+	out := make([]BookmarkNode, len(nodes))
+	for i, n := range nodes {
+		n.Page += delta
+		n.Children = offsetBookmarks(n.Children, delta)
+		out[i] = n
+	}
+	return out
+}
+
+func readBookmarks(path string) ([]BookmarkNode, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bms, err := api.Bookmarks(f, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bookmarksFromPDFCPU(bms), nil
+}
+
+// writeBookmarks replaces the outline of inPath with nodes.
+func writeBookmarks(inPath, outPath string, nodes []BookmarkNode) error {
+	return api.AddBookmarksFile(inPath, outPath, bookmarksToPDFCPU(nodes), true, nil)
+}
+
+func bookmarksFromPageMap(pageMap map[int]string) []BookmarkNode {
+	pages := make([]int, 0, len(pageMap))
+	for p := range pageMap {
+		pages = append(pages, p)
+	}
+	sort.Ints(pages)
+
+	nodes := make([]BookmarkNode, 0, len(pages))
+	for _, p := range pages {
+		nodes = append(nodes, BookmarkNode{Title: pageMap[p], Page: p})
+	}
+	return nodes
+}
+
+type headingLine struct {
+	page int
+	size float64
+	text string
+	yMin float64
+	yMax float64
+}
+
+// lineFontSize approximates the font size of a line by the mean height of
+// its word boxes, rounded to half a point so that lines set in the same
+// font compare equal.
+func lineFontSize(l utils.TextLine) float64 {
+	if len(l.Words) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, w := range l.Words {
+		sum += w.YMax - w.YMin
+	}
+	return math.Round(sum/float64(len(l.Words))*2) / 2
+}
+
+// bookmarksFromHeadings detects headings as short lines set noticeably
+// larger than the body text. The largest heading sizes become outline
+// levels, down to maxLevel; consecutive lines in the same heading size are
+// joined so that wrapped headings produce a single entry.
+func bookmarksFromHeadings(layout []utils.TextPage, maxLevel int) []BookmarkNode {
+	sizeChars := map[float64]int{}
+	var lines []headingLine
+	for _, page := range layout {
+		for _, l := range page.Lines {
+			size := lineFontSize(l)
+			text := strings.TrimSpace(l.Text())
+			if size == 0 || text == "" {
+				continue
+			}
+			sizeChars[size] += len(text)
+			lines = append(lines, headingLine{page: page.Number, size: size, text: text, yMin: l.Words[0].YMin, yMax: l.Words[0].YMax})
+		}
+	}
+
+	var body float64
+	for size, n := range sizeChars {
+		if n > sizeChars[body] || (n == sizeChars[body] && size < body) {
+			body = size
+		}
+	}
+
+	var sizes []float64
+	for size := range sizeChars {
+		if size >= body*headingMinRatio {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(sizes)))
+	if len(sizes) > maxLevel {
+		sizes = sizes[:maxLevel]
+	}
+	level := map[float64]int{}
+	for i, size := range sizes {
+		level[size] = i + 1
+	}
+
+	var headings []headingLine
+	for _, l := range lines {
+		if level[l.size] == 0 || len(l.text) > headingMaxLength || !strings.ContainsFunc(l.text, unicode.IsLetter) {
+			continue
+		}
+		if n := len(headings); n > 0 {
+			last := &headings[n-1]
+			if last.page == l.page && last.size == l.size && l.yMin-last.yMax < l.size {
+				last.text += " " + l.text
+				last.yMax = l.yMax
+				continue
+			}
+		}
+		headings = append(headings, l)
+	}
+
+	var roots []BookmarkNode
+	var insert func(nodes *[]BookmarkNode, n BookmarkNode, depth int)
+	insert = func(nodes *[]BookmarkNode, n BookmarkNode, depth int) {
+		if depth > 1 && len(*nodes) > 0 {
+			insert(&(*nodes)[len(*nodes)-1].Children, n, depth-1)
+			return
+		}
+		*nodes = append(*nodes, n)
+	}
+	for _, h := range headings {
+		insert(&roots, BookmarkNode{Title: h.text, Page: h.page}, level[h.size])
+	}
+	return roots
+}
+
+func BookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[BookmarksHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req BookmarksRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+	switch req.Mode {
+	case "export", "replace":
+	case "generate":
+		if req.Source != "headings" && req.Source != "pagemap" {
+			jsonError(w, "Invalid source. Use 'headings' or 'pagemap'", http.StatusBadRequest)
+			return
+		}
+	default:
+		jsonError(w, "Invalid mode. Use 'export', 'replace' or 'generate'", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "bookmarks-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	if req.Mode == "export" {
+		nodes, err := readBookmarks(inputPath)
+		if err != nil {
+			jsonError(w, "Failed to read bookmarks: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"bookmarks": nodes,
+		})
+		fmt.Println("[BookmarksHandler] ✅ Done in", time.Since(start))
+		return
+	}
+
+	pageCount, err := api.PageCountFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var nodes []BookmarkNode
+	switch {
+	case req.Mode == "replace":
+		nodes = req.Bookmarks
+	case req.Source == "pagemap":
+		nodes = bookmarksFromPageMap(req.PageMap)
+	default:
+		if req.MaxLevel <= 0 {
+			req.MaxLevel = defaultHeadingLevels
+		}
+		layout, err := utils.ExtractTextLayout(r.Context(), inputPath)
+		if err != nil {
+			fmt.Println("[BookmarksHandler] ❌ Text extraction failed:", err)
+			jsonError(w, "Failed to read PDF text: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		nodes = bookmarksFromHeadings(layout, req.MaxLevel)
+	}
+	if len(nodes) == 0 {
+		jsonError(w, "No bookmarks to write", http.StatusBadRequest)
+		return
+	}
+	if err := validateBookmarks(nodes, 1, pageCount); err != nil {
+		jsonError(w, "Invalid bookmarks: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	outputPath := inputPath + "-bookmarks.pdf"
+	defer os.Remove(outputPath)
+
+	if err := writeBookmarks(inputPath, outputPath, nodes); err != nil {
+		fmt.Println("[BookmarksHandler] ❌ Failed to write bookmarks:", err)
+		jsonError(w, "Failed to write bookmarks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("bookmarks/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":       url,
+		"bookmarks": nodes,
+	})
+
+	fmt.Println("[BookmarksHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/pageselect"
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+type CleanScanRequest struct {
+	Pages          pageselect.Selection `json:"pages"`          // pages to clean, default all
+	RemoveBlank    bool                 `json:"removeBlank"`    // drop pages with less ink than BlankThreshold
+	BlankThreshold float64              `json:"blankThreshold"` // ink coverage in percent, default 0.3
+	Deskew         bool                 `json:"deskew"`
+	Despeckle      bool                 `json:"despeckle"`
+	AutoRotate     bool                 `json:"autoRotate"` // turn sideways and upside-down pages upright
+	Bilevel        bool                 `json:"bilevel"`    // store cleaned pages as 1-bit black and white
+	DPI            int                  `json:"dpi"`        // rendering resolution, default 300
+}
+
+// CleanScanPageReport describes what happened to one page of the upload.
+// Pages left untouched are not reported.
+type CleanScanPageReport struct {
+	Page          int      `json:"page"`
+	NewPage       int      `json:"newPage,omitempty"` // position in the output; absent when dropped
+	Dropped       bool     `json:"dropped,omitempty"`
+	InkCoverage   *float64 `json:"inkCoverage,omitempty"` // percent, when blank detection ran
+	Rotated       int      `json:"rotated,omitempty"`     // degrees clockwise
+	SkewAngle     float64  `json:"skewAngle,omitempty"`
+	SpecksRemoved int      `json:"specksRemoved,omitempty"`
+	Bilevel       bool     `json:"bilevel,omitempty"`
+}
+
+const (
+	// minOrientationConfidence is the tesseract score below which a
+	// detected orientation is ignored; see utils.DetectOrientation.
+	minOrientationConfidence = 2
+	// despeckleArea is the largest speck removed at 300 dpi, in pixels. A
+	// full stop in 8pt type covers about twice that.
+	despeckleArea = 6
+)
+
+func (rep CleanScanPageReport) altered() bool {
+	return rep.Dropped || rep.Rotated != 0 || rep.SkewAngle != 0 || rep.SpecksRemoved > 0 || rep.Bilevel
+}
+
+// bilevelPagePDF writes a single-page PDF of the given size in points
+// holding img as a 1-bit image. pdfcpu only imports 8-bit images, so the
+// page is imported as gray first and its image then swapped for the
+// packed one.
+func bilevelPagePDF(img *image.Gray, pdfPath string, width, height float64) error {
+	grayPath := strings.TrimSuffix(pdfPath, ".pdf") + "-gray.png"
+	f, err := os.Create(grayPath)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, img)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(grayPath)
+
+	if err := imagePagePDF(grayPath, pdfPath, width, height); err != nil {
+		return err
+	}
+	ctx, err := api.ReadContextFile(pdfPath)
+	if err != nil {
+		return err
+	}
+	pageDict, _, _, err := ctx.PageDict(1, false)
+	if err != nil || pageDict == nil {
+		return fmt.Errorf("cannot read imported page")
+	}
+	res, err := ctx.DereferenceDict(pageDict["Resources"])
+	if err != nil || res == nil {
+		return fmt.Errorf("imported page has no resources")
+	}
+	xobjs, err := ctx.DereferenceDict(res["XObject"])
+	if err != nil || len(xobjs) == 0 {
+		return fmt.Errorf("imported page has no image")
+	}
+
+	// DeviceGray at 1 bit per component: 0 is black, 1 is white, rows
+	// padded to whole bytes.
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	stride := (w + 7) / 8
+	packed := make([]byte, stride*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if img.Pix[y*img.Stride+x] != 0 {
+				packed[y*stride+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+
+	sd, err := ctx.NewStreamDictForBuf(packed)
+	if err != nil {
+		return err
+	}
+	sd.InsertName("Type", "XObject")
+	sd.InsertName("Subtype", "Image")
+	sd.InsertInt("Width", w)
+	sd.InsertInt("Height", h)
+	sd.InsertName("ColorSpace", "DeviceGray")
+	sd.InsertInt("BitsPerComponent", 1)
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+
+	for _, o := range xobjs {
+		ir, ok := o.(types.IndirectRef)
+		if !ok {
+			continue
+		}
+		entry, found := ctx.FindTableEntryForIndRef(&ir)
+		if !found {
+			return fmt.Errorf("imported image is missing")
+		}
+		entry.Object = *sd
+		break
+	}
+	return api.WriteContextFile(ctx, pdfPath)
+}
+
+// cleanPage applies the raster clean-ups to a rendered page and returns the
+// cleaned page as an image-only PDF, or "" when nothing changed.
+func cleanPage(pngPath string, req CleanScanRequest, width, height float64, rep *CleanScanPageReport) (string, error) {
+	f, err := os.Open(pngPath)
+	if err != nil {
+		return "", err
+	}
+	src, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	changed := false
+	if req.Deskew {
+		if angle := utils.SkewAngle(img, maxDeskewDegrees); math.Abs(angle) >= minDeskewDegrees {
+			img = utils.RotateImage(img, angle)
+			rep.SkewAngle = math.Round(angle*100) / 100
+			changed = true
+		}
+	}
+	if req.Despeckle {
+		scale := float64(req.DPI) / 300
+		if n := utils.Despeckle(img, max(1, int(despeckleArea*scale*scale))); n > 0 {
+			rep.SpecksRemoved = n
+			changed = true
+		}
+	}
+
+	pdfPath := strings.TrimSuffix(pngPath, ".png") + "-clean.pdf"
+	if req.Bilevel {
+		rep.Bilevel = true
+		return pdfPath, bilevelPagePDF(utils.Binarize(img), pdfPath, width, height)
+	}
+	if !changed {
+		return "", nil
+	}
+
+	out, err := os.Create(pngPath)
+	if err != nil {
+		return "", err
+	}
+	if err := png.Encode(out, img); err != nil {
+		out.Close()
+		return "", err
+	}
+	out.Close()
+	return pdfPath, imagePagePDF(pngPath, pdfPath, width, height)
+}
+
+func CleanScanHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[CleanScanHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	var req CleanScanRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+	if !req.RemoveBlank && !req.Deskew && !req.Despeckle && !req.AutoRotate && !req.Bilevel {
+		jsonError(w, "Nothing to do. Enable 'removeBlank', 'deskew', 'despeckle', 'autoRotate' or 'bilevel'", http.StatusBadRequest)
+		return
+	}
+	if req.BlankThreshold <= 0 {
+		req.BlankThreshold = defaultBlankThreshold
+	}
+	if req.DPI == 0 {
+		req.DPI = defaultOCRDPI
+	}
+	if req.DPI < minOCRDPI || req.DPI > maxOCRDPI {
+		jsonError(w, fmt.Sprintf("'dpi' must be between %d and %d", minOCRDPI, maxOCRDPI), http.StatusBadRequest)
+		return
+	}
+	if req.AutoRotate {
+		langs, err := utils.TesseractLanguages(r.Context())
+		if err != nil || !slices.Contains(langs, "osd") {
+			fmt.Println("[CleanScanHandler] ❌ Orientation detection unavailable:", err)
+			jsonError(w, "Orientation detection is not available", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "cleanscan-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	pages, err := req.Pages.PagesOrAll(ctx.PageCount)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reports := map[int]*CleanScanPageReport{}
+	for _, p := range pages {
+		reports[p] = &CleanScanPageReport{Page: p}
+	}
+
+	dropped := map[int]bool{}
+	if req.RemoveBlank {
+		coverage, err := utils.InkCoverage(r.Context(), inputPath)
+		if err != nil {
+			jsonError(w, "Failed to analyse pages: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if len(coverage) != ctx.PageCount {
+			jsonError(w, fmt.Sprintf("Page analysis covered %d of %d pages", len(coverage), ctx.PageCount), http.StatusInternalServerError)
+			return
+		}
+		for _, p := range pages {
+			ink := math.Round(coverage[p-1]*100*1000) / 1000
+			reports[p].InkCoverage = &ink
+			if coverage[p-1]*100 < req.BlankThreshold {
+				dropped[p] = true
+				reports[p].Dropped = true
+			}
+		}
+		if len(dropped) == ctx.PageCount {
+			jsonError(w, "Every page of the PDF is blank", http.StatusBadRequest)
+			return
+		}
+	}
+
+	workDir, err := os.MkdirTemp("", "cleanscan-")
+	if err != nil {
+		jsonError(w, "Failed to create temp dir", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	raster := req.Deskew || req.Despeckle || req.Bilevel
+	rebuilt := map[int]string{}
+	for _, p := range pages {
+		if dropped[p] || (!raster && !req.AutoRotate) {
+			continue
+		}
+		rep := reports[p]
+
+		prefix := filepath.Join(workDir, "page-"+strconv.Itoa(p))
+		pngPath, err := utils.RenderPage(r.Context(), inputPath, p, req.DPI, prefix)
+		if err != nil {
+			fmt.Println("[CleanScanHandler] ❌ Rendering failed:", err)
+			jsonError(w, fmt.Sprintf("Failed to render page %d: %v", p, err), http.StatusInternalServerError)
+			return
+		}
+
+		if req.AutoRotate {
+			rotate, confidence, err := utils.DetectOrientation(r.Context(), pngPath, req.DPI)
+			if err != nil {
+				fmt.Println("[CleanScanHandler] ❌ Orientation detection failed:", err)
+				jsonError(w, fmt.Sprintf("Failed to detect orientation of page %d: %v", p, err), http.StatusInternalServerError)
+				return
+			}
+			if confidence >= minOrientationConfidence {
+				rep.Rotated = normalizeRotation(rotate)
+			}
+		}
+
+		if raster {
+			box, rot, err := pageFrame(ctx, p)
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			width, height := box.Width(), box.Height()
+			if rot == 90 || rot == 270 {
+				width, height = height, width
+			}
+			pdfPath, err := cleanPage(pngPath, req, width, height, rep)
+			if err != nil {
+				fmt.Println("[CleanScanHandler] ❌ Cleaning failed:", err)
+				jsonError(w, fmt.Sprintf("Failed to clean page %d: %v", p, err), http.StatusInternalServerError)
+				return
+			}
+			if pdfPath != "" {
+				rebuilt[p] = pdfPath
+			}
+		}
+		os.Remove(pngPath)
+	}
+
+	var kept []int
+	rotations := map[int][]int{} // degrees -> output pages
+	var report []CleanScanPageReport
+	for p := 1; p <= ctx.PageCount; p++ {
+		rep := reports[p]
+		if dropped[p] {
+			report = append(report, *rep)
+			continue
+		}
+		kept = append(kept, p)
+		if rep == nil || !rep.altered() {
+			continue
+		}
+		rep.NewPage = len(kept)
+		if rep.Rotated != 0 {
+			rotations[rep.Rotated] = append(rotations[rep.Rotated], rep.NewPage)
+		}
+		report = append(report, *rep)
+	}
+
+	outputPath := inputPath
+	if len(dropped) > 0 || len(rebuilt) > 0 || len(rotations) > 0 {
+		outputPath = filepath.Join(workDir, "clean.pdf")
+		args := []string{inputPath}
+		for deg, ps := range rotations {
+			args = append(args, fmt.Sprintf("--rotate=+%d:%s", deg, strings.Join(pageselect.Format(ps), ",")))
+		}
+		args = append(args, "--pages")
+		args = append(args, qpdfPageArgs(inputPath, kept, rebuilt)...)
+		if _, err := utils.RunQPDF(r.Context(), append(args, "--", outputPath)...); err != nil {
+			fmt.Println("[CleanScanHandler] ❌ Assembly failed:", err)
+			jsonError(w, "Failed to assemble cleaned PDF: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	fmt.Printf("[CleanScanHandler] ✅ Dropped %d page(s), altered %d\n", len(dropped), len(report)-len(dropped))
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("clean-scan/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":       url,
+		"pageCount": len(kept),
+		"dropped":   len(dropped),
+		"altered":   len(report) - len(dropped),
+		"pages":     report,
+	})
+
+	fmt.Println("[CleanScanHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,620 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/color"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// CompareRequest compares the uploaded 'original' PDF with the 'revised'
+// one. The text diff is always computed; the rendered pages are only
+// compared when Visual is set.
+type CompareRequest struct {
+	IgnoreCase bool `json:"ignoreCase"`
+	Visual     bool `json:"visual"`    // also diff the rendered pages pixel by pixel
+	DPI        int  `json:"dpi"`       // resolution of the visual diff, default 72
+	Tolerance  int  `json:"tolerance"` // per-channel difference (1-255) below which pixels count as equal, default 32
+}
+
+type CompareChange struct {
+	Type         string `json:"type"` // "insert", "delete" or "move"
+	Text         string `json:"text"`
+	OriginalPage int    `json:"originalPage"`
+	RevisedPage  int    `json:"revisedPage"`
+}
+
+// ComparePage reports the changes found on one page of the revised
+// document. Deleted text is listed on the page where it used to be.
+type ComparePage struct {
+	Page        int             `json:"page"`
+	Insertions  int             `json:"insertions"`
+	Deletions   int             `json:"deletions"`
+	Moves       int             `json:"moves"`
+	Changes     []CompareChange `json:"changes,omitempty"`
+	VisualScore *float64        `json:"visualScore,omitempty"` // share of pixels that differ, 0 to 1
+	DiffImage   string          `json:"diffImage,omitempty"`
+}
+
+const (
+	defaultCompareDPI       = 72
+	maxCompareDPI           = 300
+	defaultCompareTolerance = 32
+	// minMoveWords keeps short paragraphs such as headings or page numbers
+	// that happen to repeat from being reported as moves.
+	minMoveWords = 4
+	// maxDiffCells bounds the LCS table. Longer stretches of changed text
+	// are reported as replaced outright instead of being diffed.
+	maxDiffCells = 4 << 20
+)
+
+var (
+	compareInsertColor = color.SimpleColor{R: .55, G: .9, B: .55}
+	compareMoveColor   = color.SimpleColor{R: .55, G: .75, B: 1}
+)
+
+type compareParagraph struct {
+	key   string
+	words []layoutWord
+}
+
+type compareChange struct {
+	kind     string
+	words    []layoutWord // revised words for inserts and moves, original words for deletes
+	origPage int
+	revPage  int
+	// anchor is the revised word a deletion follows, or precedes when
+	// before is set because the deletion comes ahead of all revised text.
+	anchor *layoutWord
+	before bool
+}
+
+func (c compareChange) text() string {
+	parts := make([]string, len(c.words))
+	for i, w := range c.words {
+		parts[i] = w.text
+	}
+	return strings.Join(parts, " ")
+}
+
+type editOp struct {
+	kind byte // '=', '-' or '+'
+	a, b int
+}
+
+// diffKeys returns the edit script turning a into b, based on their
+// longest common subsequence.
+func diffKeys(a, b []string) []editOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := make([]editOp, 0, len(a)+len(b))
+	for i := 0; i < pre; i++ {
+		ops = append(ops, editOp{'=', i, i})
+	}
+
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	n, m := len(ma), len(mb)
+	if n > 0 && m > 0 && (n+1)*(m+1) <= maxDiffCells {
+		// lcs[i*(m+1)+j] is the LCS length of ma[i:] and mb[j:].
+		lcs := make([]int32, (n+1)*(m+1))
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				switch {
+				case ma[i] == mb[j]:
+					lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+				case lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+					lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j]
+				default:
+					lcs[i*(m+1)+j] = lcs[i*(m+1)+j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n && j < m {
+			switch {
+			case ma[i] == mb[j]:
+				ops = append(ops, editOp{'=', pre + i, pre + j})
+				i++
+				j++
+			case lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+				ops = append(ops, editOp{'-', pre + i, -1})
+				i++
+			default:
+				ops = append(ops, editOp{'+', -1, pre + j})
+				j++
+			}
+		}
+		for ; i < n; i++ {
+			ops = append(ops, editOp{'-', pre + i, -1})
+		}
+		for ; j < m; j++ {
+			ops = append(ops, editOp{'+', -1, pre + j})
+		}
+	} else {
+		for i := 0; i < n; i++ {
+			ops = append(ops, editOp{'-', pre + i, -1})
+		}
+		for j := 0; j < m; j++ {
+			ops = append(ops, editOp{'+', -1, pre + j})
+		}
+	}
+
+	for i := suf; i > 0; i-- {
+		ops = append(ops, editOp{'=', len(a) - i, len(b) - i})
+	}
+	return ops
+}
+
+// compareParagraphs splits a text layout into paragraphs, taking each block
+// poppler found on a page as one paragraph.
+func compareParagraphs(layout []utils.TextPage, ignoreCase bool) []compareParagraph {
+	var paras []compareParagraph
+	line := 0
+	for _, page := range layout {
+		block := -1
+		for _, l := range page.Lines {
+			if len(l.Words) == 0 {
+				continue
+			}
+			line++
+			if l.Block != block {
+				paras = append(paras, compareParagraph{})
+				block = l.Block
+			}
+			p := &paras[len(paras)-1]
+			for _, w := range l.Words {
+				key := w.Text
+				if ignoreCase {
+					key = strings.ToLower(key)
+				}
+				p.words = append(p.words, layoutWord{key: key, text: w.Text, page: page.Number, line: line, box: w})
+			}
+		}
+	}
+	for i := range paras {
+		keys := make([]string, len(paras[i].words))
+		for j, w := range paras[i].words {
+			keys[j] = w.key
+		}
+		paras[i].key = strings.Join(keys, " ")
+	}
+	return paras
+}
+
+func paragraphKeys(paras []compareParagraph) []string {
+	keys := make([]string, len(paras))
+	for i, p := range paras {
+		keys[i] = p.key
+	}
+	return keys
+}
+
+func wordKeys(words []layoutWord) []string {
+	keys := make([]string, len(words))
+	for i, w := range words {
+		keys[i] = w.key
+	}
+	return keys
+}
+
+func wordPage(w *layoutWord) int {
+	if w == nil {
+		return 1
+	}
+	return w.page
+}
+
+// compareDocuments diffs the two layouts paragraph by paragraph first and
+// then word by word inside each stretch of changed paragraphs, so that a
+// reflowed page break does not show up as a change. A removed paragraph
+// that reappears unchanged elsewhere is reported as a move.
+func compareDocuments(orig, rev []utils.TextPage, ignoreCase bool) []compareChange {
+	pa := compareParagraphs(orig, ignoreCase)
+	pb := compareParagraphs(rev, ignoreCase)
+	ops := diffKeys(paragraphKeys(pa), paragraphKeys(pb))
+
+	deleted := map[string][]int{}
+	for _, op := range ops {
+		if op.kind == '-' && len(pa[op.a].words) >= minMoveWords {
+			deleted[pa[op.a].key] = append(deleted[pa[op.a].key], op.a)
+		}
+	}
+	movedFrom := map[int]int{} // revised paragraph -> original paragraph
+	moved := map[int]bool{}    // original paragraphs that moved
+	for _, op := range ops {
+		if op.kind != '+' {
+			continue
+		}
+		if cands := deleted[pb[op.b].key]; len(cands) > 0 {
+			movedFrom[op.b] = cands[0]
+			moved[cands[0]] = true
+			deleted[pb[op.b].key] = cands[1:]
+		}
+	}
+
+	var firstRev *layoutWord
+	for i := range pb {
+		if len(pb[i].words) > 0 {
+			firstRev = &pb[i].words[0]
+			break
+		}
+	}
+
+	var changes []compareChange
+	var lastA, lastB *layoutWord
+	var hunkA, hunkB []layoutWord
+
+	flushHunk := func() {
+		var del, ins []layoutWord
+		flush := func() {
+			if len(del) > 0 {
+				c := compareChange{kind: "delete", words: del, origPage: del[0].page, revPage: wordPage(lastB), anchor: lastB}
+				if lastB == nil && firstRev != nil {
+					c.anchor, c.before, c.revPage = firstRev, true, firstRev.page
+				}
+				changes = append(changes, c)
+			}
+			if len(ins) > 0 {
+				changes = append(changes, compareChange{kind: "insert", words: ins, origPage: wordPage(lastA), revPage: ins[0].page})
+			}
+			del, ins = nil, nil
+		}
+		for _, op := range diffKeys(wordKeys(hunkA), wordKeys(hunkB)) {
+			switch op.kind {
+			case '-':
+				del = append(del, hunkA[op.a])
+			case '+':
+				ins = append(ins, hunkB[op.b])
+			default:
+				flush()
+				lastA, lastB = &hunkA[op.a], &hunkB[op.b]
+			}
+		}
+		flush()
+		hunkA, hunkB = nil, nil
+	}
+
+	for _, op := range ops {
+		switch op.kind {
+		case '-':
+			if !moved[op.a] {
+				hunkA = append(hunkA, pa[op.a].words...)
+			}
+		case '+':
+			if from, ok := movedFrom[op.b]; ok {
+				flushHunk()
+				words := pb[op.b].words
+				changes = append(changes, compareChange{kind: "move", words: words, origPage: pa[from].words[0].page, revPage: words[0].page})
+				lastB = &words[len(words)-1]
+				continue
+			}
+			hunkB = append(hunkB, pb[op.b].words...)
+		default:
+			flushHunk()
+			if words := pa[op.a].words; len(words) > 0 {
+				lastA = &words[len(words)-1]
+			}
+			if words := pb[op.b].words; len(words) > 0 {
+				lastB = &words[len(words)-1]
+			}
+		}
+	}
+	flushHunk()
+	return changes
+}
+
+// compareAnnotations marks the changes on the revised document: inserted
+// and moved text is highlighted and deleted text becomes a caret carrying
+// the removed words.
+func compareAnnotations(ctx *model.Context, changes []compareChange) (map[int][]model.AnnotationRenderer, error) {
+	mb := newMarkupBuilder(ctx, "Compare")
+	for _, c := range changes {
+		var err error
+		switch c.kind {
+		case "delete":
+			if c.anchor != nil {
+				err = mb.caret(*c.anchor, c.before, &color.Red, "Deleted: "+c.text(), "Deleted")
+			}
+		case "move":
+			err = mb.highlight(c.words, &compareMoveColor, fmt.Sprintf("Moved from page %d of the original", c.origPage), "Moved")
+		default:
+			err = mb.highlight(c.words, &compareInsertColor, "Inserted: "+c.text(), "Inserted")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mb.annots, nil
+}
+
+func renderRGBA(ctx context.Context, path string, page, dpi int, prefix string) (*image.RGBA, error) {
+	pngPath, err := utils.RenderPage(ctx, path, page, dpi, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(pngPath)
+
+	f, err := os.Open(pngPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode page %d: %v", page, err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	return img, nil
+}
+
+// pixelDiff compares two renderings of a page. It returns the share of
+// pixels that differ by more than tolerance in any channel, and an image of
+// the revised page washed out with the differing pixels painted red. Areas
+// covered by only one of the images count as changed.
+func pixelDiff(a, b *image.RGBA, tolerance int) (float64, *image.RGBA) {
+	bounds := a.Bounds().Union(b.Bounds())
+	out := image.NewRGBA(bounds)
+	changed := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := image.Pt(x, y)
+			inA, inB := p.In(a.Bounds()), p.In(b.Bounds())
+			diff := !inA || !inB
+			if !diff {
+				ia, ib := a.PixOffset(x, y), b.PixOffset(x, y)
+				for c := 0; c < 3; c++ {
+					d := int(a.Pix[ia+c]) - int(b.Pix[ib+c])
+					if d > tolerance || -d > tolerance {
+						diff = true
+						break
+					}
+				}
+			}
+
+			o := out.PixOffset(x, y)
+			if diff {
+				changed++
+				out.Pix[o], out.Pix[o+1], out.Pix[o+2], out.Pix[o+3] = 255, 0, 0, 255
+				continue
+			}
+			ib := b.PixOffset(x, y)
+			lum := (299*int(b.Pix[ib]) + 587*int(b.Pix[ib+1]) + 114*int(b.Pix[ib+2])) / 1000
+			v := uint8(255 - (255-lum)/4)
+			out.Pix[o], out.Pix[o+1], out.Pix[o+2], out.Pix[o+3] = v, v, v, 255
+		}
+	}
+	total := bounds.Dx() * bounds.Dy()
+	if total == 0 {
+		return 0, out
+	}
+	return float64(changed) / float64(total), out
+}
+
+// visualDiff renders the same page of both documents and compares them.
+// The diff image is only written when something changed.
+func visualDiff(ctx context.Context, origPath, revPath string, page int, req CompareRequest, workDir string) (float64, string, error) {
+	prefix := filepath.Join(workDir, "page-"+strconv.Itoa(page))
+	a, err := renderRGBA(ctx, origPath, page, req.DPI, prefix+"-a")
+	if err != nil {
+		return 0, "", err
+	}
+	b, err := renderRGBA(ctx, revPath, page, req.DPI, prefix+"-b")
+	if err != nil {
+		return 0, "", err
+	}
+
+	score, img := pixelDiff(a, b, req.Tolerance)
+	if score == 0 {
+		return 0, "", nil
+	}
+	diffPath := prefix + "-diff.png"
+	f, err := os.Create(diffPath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return 0, "", err
+	}
+	return score, diffPath, nil
+}
+
+func CompareHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[CompareHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	var req CompareRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.DPI <= 0 {
+		req.DPI = defaultCompareDPI
+	}
+	if req.DPI > maxCompareDPI {
+		jsonError(w, fmt.Sprintf("'dpi' must not exceed %d", maxCompareDPI), http.StatusBadRequest)
+		return
+	}
+	if req.Tolerance <= 0 {
+		req.Tolerance = defaultCompareTolerance
+	}
+	if req.Tolerance > 255 {
+		jsonError(w, "'tolerance' must be between 1 and 255", http.StatusBadRequest)
+		return
+	}
+
+	origPath, ok := saveUploadedField(w, r, "original", "compare-orig-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(origPath)
+
+	revPath, ok := saveUploadedField(w, r, "revised", "compare-rev-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(revPath)
+
+	origLayout, err := utils.ExtractTextLayout(r.Context(), origPath)
+	if err != nil {
+		fmt.Println("[CompareHandler] ❌ Text extraction failed:", err)
+		jsonError(w, "Failed to read original PDF text: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	revLayout, err := utils.ExtractTextLayout(r.Context(), revPath)
+	if err != nil {
+		fmt.Println("[CompareHandler] ❌ Text extraction failed:", err)
+		jsonError(w, "Failed to read revised PDF text: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	changes := compareDocuments(origLayout, revLayout, req.IgnoreCase)
+
+	ctx, err := api.ReadContextFile(revPath)
+	if err != nil {
+		jsonError(w, "Failed to read revised PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	annots, err := compareAnnotations(ctx, changes)
+	if err == nil && len(annots) > 0 {
+		_, err = pdfcpu.AddAnnotationsMap(ctx, annots, false)
+	}
+	if err != nil {
+		fmt.Println("[CompareHandler] ❌ Annotating failed:", err)
+		jsonError(w, "Failed to annotate changes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	outputPath := revPath + "-compare.pdf"
+	defer os.Remove(outputPath)
+
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		jsonError(w, "Failed to write PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pages := make([]ComparePage, max(len(origLayout), len(revLayout)))
+	for i := range pages {
+		pages[i].Page = i + 1
+	}
+	summary := map[string]int{"insertions": 0, "deletions": 0, "moves": 0}
+	for _, c := range changes {
+		if c.revPage < 1 || c.revPage > len(pages) {
+			continue
+		}
+		p := &pages[c.revPage-1]
+		switch c.kind {
+		case "insert":
+			p.Insertions++
+			summary["insertions"]++
+		case "delete":
+			p.Deletions++
+			summary["deletions"]++
+		case "move":
+			p.Moves++
+			summary["moves"]++
+		}
+		p.Changes = append(p.Changes, CompareChange{Type: c.kind, Text: c.text(), OriginalPage: c.origPage, RevisedPage: c.revPage})
+	}
+
+	batch := time.Now().UnixNano()
+
+	if req.Visual {
+		workDir, err := os.MkdirTemp("", "compare-")
+		if err != nil {
+			jsonError(w, "Failed to create temp dir", http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(workDir)
+
+		for i := range pages {
+			p := &pages[i]
+			score := 1.0
+			if p.Page <= len(origLayout) && p.Page <= len(revLayout) {
+				var diffPath string
+				score, diffPath, err = visualDiff(r.Context(), origPath, revPath, p.Page, req, workDir)
+				if err != nil {
+					fmt.Println("[CompareHandler] ❌ Visual diff failed:", err)
+					jsonError(w, fmt.Sprintf("Failed to compare page %d: %v", p.Page, err), http.StatusInternalServerError)
+					return
+				}
+				if diffPath != "" {
+					f, err := os.Open(diffPath)
+					if err != nil {
+						jsonError(w, "Failed to open diff image", http.StatusInternalServerError)
+						return
+					}
+					p.DiffImage, err = utils.UploadStreamToR2WithType(fmt.Sprintf("compare/%d/page-%d.png", batch, p.Page), f, "image/png")
+					f.Close()
+					if err != nil {
+						jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+						return
+					}
+				}
+			}
+			p.VisualScore = &score
+		}
+	}
+
+	changedPages := 0
+	for _, p := range pages {
+		if len(p.Changes) > 0 || (p.VisualScore != nil && *p.VisualScore > 0) {
+			changedPages++
+		}
+	}
+	summary["changedPages"] = changedPages
+	fmt.Printf("[CompareHandler] ✅ %d change(s) on %d page(s)\n", len(changes), changedPages)
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	url, err := utils.UploadStreamToR2(fmt.Sprintf("compare/%d/annotated.pdf", batch), outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":     url,
+		"summary": summary,
+		"pages":   pages,
+	})
+
+	fmt.Println("[CompareHandler] ✅ Done in", time.Since(start))
+}
@@ -1,17 +1,240 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/exec"
 	"time"
 
 	"github.com/Lucifer7355/PDF/utils"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
+type CompressRequest struct {
+	Level       string `json:"level"`       // "lossless" (default), "balanced", "aggressive" or "custom"
+	DPI         int    `json:"dpi"`         // target image resolution, "custom" only
+	JPEGQuality int    `json:"jpegQuality"` // 1-100, "custom" only
+	Grayscale   bool   `json:"grayscale"`   // convert colour content to gray (lossy levels only)
+	TargetBytes int64  `json:"targetBytes"` // try progressively stronger settings until the output fits
+}
+
+// CompressionSettings describes one ghostscript recompression pass. A zero
+// DPI means images are left untouched and only structure is optimized.
+type CompressionSettings struct {
+	DPI           int  `json:"dpi,omitempty"`
+	JPEGQuality   int  `json:"jpegQuality,omitempty"`
+	Grayscale     bool `json:"grayscale,omitempty"`
+	ObjectStreams bool `json:"objectStreams,omitempty"` // repack objects into compressed object streams with qpdf
+}
+
+var compressionPresets = map[string]CompressionSettings{
+	"lossless":   {},
+	"balanced":   {DPI: 150, JPEGQuality: 80},
+	"aggressive": {DPI: 96, JPEGQuality: 60},
+}
+
+// targetSizeLadder lists the settings tried, weakest first, when compressing
+// towards a target file size.
+var targetSizeLadder = []CompressionSettings{
+	{},
+	{ObjectStreams: true},
+	{DPI: 200, JPEGQuality: 85, ObjectStreams: true},
+	{DPI: 150, JPEGQuality: 80, ObjectStreams: true},
+	{DPI: 150, JPEGQuality: 65, ObjectStreams: true},
+	{DPI: 120, JPEGQuality: 60, ObjectStreams: true},
+	{DPI: 96, JPEGQuality: 55, ObjectStreams: true},
+	{DPI: 72, JPEGQuality: 45, ObjectStreams: true},
+	{DPI: 60, JPEGQuality: 35, ObjectStreams: true},
+	{DPI: 50, JPEGQuality: 25, ObjectStreams: true},
+}
+
+func compressionSettingsFor(req CompressRequest) (CompressionSettings, error) {
+	level := req.Level
+	if level == "" {
+		level = "lossless"
+	}
+
+	var s CompressionSettings
+	if level == "custom" {
+		if req.DPI < 36 || req.DPI > 600 {
+			return s, fmt.Errorf("Invalid 'dpi' value (36-600)")
+		}
+		if req.JPEGQuality < 1 || req.JPEGQuality > 100 {
+			return s, fmt.Errorf("Invalid 'jpegQuality' value (1-100)")
+		}
+		s = CompressionSettings{DPI: req.DPI, JPEGQuality: req.JPEGQuality}
+	} else {
+		preset, ok := compressionPresets[level]
+		if !ok {
+			return s, fmt.Errorf("Invalid level. Use 'lossless', 'balanced', 'aggressive' or 'custom'")
+		}
+		s = preset
+	}
+
+	if req.Grayscale {
+		if s.DPI == 0 {
+			return s, fmt.Errorf("'grayscale' requires a lossy level")
+		}
+		s.Grayscale = true
+	}
+	return s, nil
+}
+
+// jpegQFactor converts a 1-100 JPEG quality into the QFactor used by
+// ghostscript's DCTEncode, following the IJG quality scaling.
+func jpegQFactor(quality int) float64 {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	return float64(scale) / 100
+}
+
+// compressPDF writes a compressed copy of inPath to outPath. Lossy settings
+// run ghostscript to downsample and JPEG-recompress images; the result is
+// then passed through pdfcpu to strip unused and duplicate objects.
+func compressPDF(ctx context.Context, inPath, outPath string, s CompressionSettings) error {
+	if s.DPI == 0 {
+		if err := api.OptimizeFile(inPath, outPath, nil); err != nil {
+			return err
+		}
+		return repackObjectStreams(ctx, outPath, s)
+	}
+
+	gsOut := outPath + ".gs.pdf"
+	defer os.Remove(gsOut)
+
+	args := []string{
+		"-sDEVICE=pdfwrite",
+		"-dCompatibilityLevel=1.5",
+		"-dDetectDuplicateImages=true",
+		"-dCompressFonts=true",
+		"-dSubsetFonts=true",
+		"-dDownsampleColorImages=true",
+		"-dDownsampleGrayImages=true",
+		"-dDownsampleMonoImages=true",
+		"-dColorImageDownsampleType=/Bicubic",
+		"-dGrayImageDownsampleType=/Bicubic",
+		"-dMonoImageDownsampleType=/Subsample",
+		fmt.Sprintf("-dColorImageResolution=%d", s.DPI),
+		fmt.Sprintf("-dGrayImageResolution=%d", s.DPI),
+		fmt.Sprintf("-dMonoImageResolution=%d", max(2*s.DPI, 300)),
+		"-dColorImageDownsampleThreshold=1.0",
+		"-dGrayImageDownsampleThreshold=1.0",
+		"-dAutoFilterColorImages=false",
+		"-dAutoFilterGrayImages=false",
+		"-dColorImageFilter=/DCTEncode",
+		"-dGrayImageFilter=/DCTEncode",
+	}
+	if s.Grayscale {
+		args = append(args,
+			"-sColorConversionStrategy=Gray",
+			"-dProcessColorModel=/DeviceGray",
+		)
+	}
+
+	dict := fmt.Sprintf("<< /QFactor %.2f /Blend 1 /HSamples [2 1 1 2] /VSamples [2 1 1 2] >>", jpegQFactor(s.JPEGQuality))
+	args = append(args,
+		"-sOutputFile="+gsOut,
+		"-c", fmt.Sprintf("<< /ColorACSImageDict %[1]s /GrayACSImageDict %[1]s /ColorImageDict %[1]s /GrayImageDict %[1]s >> setdistillerparams", dict),
+		"-f", inPath,
+	)
+
+	if out, err := utils.RunGhostscript(ctx, args...); err != nil {
+		return fmt.Errorf("ghostscript failed: %v: %s", err, out)
+	}
+	if err := api.OptimizeFile(gsOut, outPath, nil); err != nil {
+		return err
+	}
+	return repackObjectStreams(ctx, outPath, s)
+}
+
+// repackObjectStreams rewrites path in place with qpdf, packing objects into
+// object streams and recompressing every stream at the highest flate level.
+func repackObjectStreams(ctx context.Context, path string, s CompressionSettings) error {
+	if !s.ObjectStreams {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, "qpdf",
+		"--object-streams=generate",
+		"--compress-streams=y",
+		"--recompress-flate",
+		"--compression-level=9",
+		"--replace-input",
+		path,
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("qpdf failed: %v: %s", err, out)
+	}
+	return nil
+}
+
+// compressToTarget walks targetSizeLadder until an output of at most target
+// bytes is produced. It returns the smallest file obtained, the settings
+// that produced it and the number of attempts made.
+func compressToTarget(ctx context.Context, inPath string, target int64, grayscale bool) (string, CompressionSettings, int, error) {
+	var (
+		bestPath     string
+		bestSize     int64
+		bestSettings CompressionSettings
+		attempts     int
+	)
+
+	for i, s := range targetSizeLadder {
+		if grayscale && s.DPI > 0 {
+			s.Grayscale = true
+		}
+
+		outPath := fmt.Sprintf("%s-target-%d.pdf", inPath, i)
+		attempts++
+		if err := compressPDF(ctx, inPath, outPath, s); err != nil {
+			os.Remove(outPath)
+			if ctx.Err() != nil {
+				return bestPath, bestSettings, attempts, ctx.Err()
+			}
+			fmt.Printf("[CompressHandler] ⚠️  Attempt %d failed: %v\n", attempts, err)
+			continue
+		}
+
+		size, err := fileSize(outPath)
+		if err != nil {
+			os.Remove(outPath)
+			continue
+		}
+		fmt.Printf("[CompressHandler] 🔁 Attempt %d (%+v): %d bytes\n", attempts, s, size)
+
+		if bestPath == "" || size < bestSize {
+			if bestPath != "" {
+				os.Remove(bestPath)
+			}
+			bestPath, bestSize, bestSettings = outPath, size, s
+		} else {
+			os.Remove(outPath)
+		}
+
+		if bestSize <= target {
+			break
+		}
+	}
+
+	if bestPath == "" {
+		return "", bestSettings, attempts, fmt.Errorf("no compression attempt succeeded")
+	}
+	return bestPath, bestSettings, attempts, nil
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 func CompressHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	fmt.Println("[CompressHandler] ➜ Received request at", start.Format(time.RFC3339))
@@ -23,6 +246,31 @@ func CompressHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var req CompressRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			fmt.Println("[CompressHandler] ❌ Invalid meta:", err)
+			http.Error(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.TargetBytes < 0 {
+		http.Error(w, "Invalid 'targetBytes' value", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := compressionSettingsFor(req)
+	if req.TargetBytes > 0 {
+		// The target drives the settings; only grayscale is honoured.
+		settings, err = CompressionSettings{Grayscale: req.Grayscale}, nil
+	}
+	if err != nil {
+		fmt.Println("[CompressHandler] ❌ Invalid options:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	fh := r.MultipartForm.File["file"][0]
 	file, err := fh.Open()
 	if err != nil {
@@ -51,20 +299,44 @@ func CompressHandler(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("[CompressHandler] ✅ Uploaded file saved to:", inFile.Name())
 
-	outFile := filepath.Join(os.TempDir(), "compressed.pdf")
+	outFile := inFile.Name() + "-compressed.pdf"
+	attempts := 1
+	if req.TargetBytes > 0 {
+		outFile, settings, attempts, err = compressToTarget(r.Context(), inFile.Name(), req.TargetBytes, req.Grayscale)
+	} else {
+		err = compressPDF(r.Context(), inFile.Name(), outFile, settings)
+	}
 	defer os.Remove(outFile)
-
-	err = api.OptimizeFile(inFile.Name(), outFile, nil)
 	if err != nil {
 		fmt.Println("[CompressHandler] ❌ PDF compression failed:", err)
 		http.Error(w, "Failed to compress PDF", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println("[CompressHandler] ✅ Compression successful:", outFile)
+	originalSize, err := fileSize(inFile.Name())
+	if err != nil {
+		http.Error(w, "Failed to read file size", http.StatusInternalServerError)
+		return
+	}
+	compressedSize, err := fileSize(outFile)
+	if err != nil {
+		http.Error(w, "Failed to read file size", http.StatusInternalServerError)
+		return
+	}
+
+	// Never hand back something bigger than what was uploaded.
+	result := outFile
+	if compressedSize >= originalSize {
+		fmt.Printf("[CompressHandler] ⚠️  Output (%d bytes) not smaller than input (%d bytes), returning original\n", compressedSize, originalSize)
+		result = inFile.Name()
+		compressedSize = originalSize
+		settings = CompressionSettings{}
+	}
+
+	fmt.Println("[CompressHandler] ✅ Compression successful:", result)
 
 	// Stream compressed file instead of reading it entirely into memory
-	f, err := os.Open(outFile)
+	f, err := os.Open(result)
 	if err != nil {
 		fmt.Println("[CompressHandler] ❌ Error opening compressed file:", err)
 		http.Error(w, "Failed to open compressed PDF", http.StatusInternalServerError)
@@ -81,9 +353,26 @@ func CompressHandler(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("[CompressHandler] ✅ Compressed PDF uploaded to R2: %s\n", url)
 
+	ratio := 1.0
+	if originalSize > 0 {
+		ratio = float64(compressedSize) / float64(originalSize)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"url":"` + url + `"}`))
+	resp := map[string]interface{}{
+		"url":            url,
+		"originalSize":   originalSize,
+		"compressedSize": compressedSize,
+		"ratio":          ratio,
+	}
+	if req.TargetBytes > 0 {
+		resp["targetBytes"] = req.TargetBytes
+		resp["targetMet"] = compressedSize <= req.TargetBytes
+		resp["attempts"] = attempts
+		resp["settings"] = settings
+	}
+	json.NewEncoder(w).Encode(resp)
 
 	fmt.Println("[CompressHandler] ✅ Response sent in", time.Since(start))
 }
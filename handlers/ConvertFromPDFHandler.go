@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+)
+
+type ConvertFromPDFRequest struct {
+	Target string `json:"target"` // "docx", "odt", "txt", "html", "png" or "jpg"
+	DPI    int    `json:"dpi"`    // image resolution, used for "png" and "jpg"
+}
+
+// Supported conversion targets and the Content-Type of the uploaded result.
+// Image targets produce one file per page and are delivered as a ZIP.
+var convertFromPDFTargets = map[string]string{
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"odt":  "application/vnd.oasis.opendocument.text",
+	"txt":  "text/plain; charset=utf-8",
+	"html": "text/html; charset=utf-8",
+	"png":  "application/zip",
+	"jpg":  "application/zip",
+}
+
+func supportedFromPDFTargets() string {
+	var names []string
+	for t := range convertFromPDFTargets {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func ConvertFromPDFHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[ConvertFromPDFHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req ConvertFromPDFRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+
+	target := strings.ToLower(strings.TrimPrefix(req.Target, "."))
+	if target == "jpeg" {
+		target = "jpg"
+	}
+	contentType, ok := convertFromPDFTargets[target]
+	if !ok {
+		jsonError(w, fmt.Sprintf("Unsupported target format '%s'. Use one of: %s", req.Target, supportedFromPDFTargets()), http.StatusBadRequest)
+		return
+	}
+
+	dpi := req.DPI
+	if dpi == 0 {
+		dpi = 150
+	}
+	if dpi < 36 || dpi > 600 {
+		jsonError(w, "Invalid 'dpi' value (36-600)", http.StatusBadRequest)
+		return
+	}
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		jsonError(w, "Missing 'file' field", http.StatusBadRequest)
+		return
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		jsonError(w, "Unable to read uploaded file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	workDir, err := os.MkdirTemp("", "fromPDF-")
+	if err != nil {
+		jsonError(w, "Failed to create working directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	inputPath := filepath.Join(workDir, "input.pdf")
+	inputFile, err := os.Create(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.Copy(inputFile, file); err != nil {
+		inputFile.Close()
+		jsonError(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	inputFile.Close()
+
+	outputPath := filepath.Join(workDir, "output."+target)
+	var out []byte
+
+	switch target {
+	case "docx", "odt":
+		// Import through Writer rather than Draw so the result is a text document.
+		out, err = utils.ConvertWithUnoconv(r.Context(), inputPath, outputPath, target, "-d", "document", "-i", "FilterName=writer_pdf_import")
+
+	case "txt":
+		out, err = utils.RunPoppler(r.Context(), "pdftotext", "-layout", "-enc", "UTF-8", inputPath, outputPath)
+
+	case "html":
+		out, err = utils.RunPoppler(r.Context(), "pdftohtml", "-s", "-noframes", "-i", "-enc", "UTF-8", inputPath, outputPath)
+
+	case "png", "jpg":
+		pagesDir := filepath.Join(workDir, "pages")
+		if err = os.Mkdir(pagesDir, 0755); err != nil {
+			break
+		}
+		imgFlag := "-png"
+		if target == "jpg" {
+			imgFlag = "-jpeg"
+		}
+		out, err = utils.RunPoppler(r.Context(), "pdftoppm", imgFlag, "-r", strconv.Itoa(dpi), inputPath, filepath.Join(pagesDir, "page"))
+		if err != nil {
+			break
+		}
+
+		var pages []string
+		pages, err = filepath.Glob(filepath.Join(pagesDir, "page-*"))
+		if err != nil {
+			break
+		}
+		if len(pages) == 0 {
+			out, err = []byte("no pages rendered"), fmt.Errorf("no pages rendered")
+			break
+		}
+		sort.Strings(pages)
+		outputPath = filepath.Join(workDir, "pages.zip")
+		err = utils.ZipFiles(outputPath, pages)
+	}
+
+	if err != nil {
+		fmt.Println("[ConvertFromPDFHandler] ❌ Conversion failed:", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			jsonError(w, "Conversion timed out", http.StatusGatewayTimeout)
+			return
+		}
+		jsonError(w, fmt.Sprintf("Conversion to %s failed: %s", target, strings.TrimSpace(string(out))), http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("[ConvertFromPDFHandler] ✅ Converted to:", outputPath)
+
+	resultFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open converted file", http.StatusInternalServerError)
+		return
+	}
+	defer resultFile.Close()
+
+	uploadKey := fmt.Sprintf("converted-from-pdf/%d%s", time.Now().UnixNano(), filepath.Ext(outputPath))
+	url, err := utils.UploadStreamToR2WithType(uploadKey, resultFile, contentType)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": url,
+	})
+
+	fmt.Println("[ConvertFromPDFHandler] ✅ Done in", time.Since(start))
+}
@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Lucifer7355/PDF/utils"
@@ -23,6 +28,150 @@ func jsonConvertPDFError(w http.ResponseWriter, msg string, code int) {
 	json.NewEncoder(w).Encode(ConvertPDFError{Error: msg})
 }
 
+type ConvertToPDFRequest struct {
+	PDFA                string `json:"pdfa"`                // "1b", "2b" or "3b" for PDF/A output
+	PageRange           string `json:"pageRange"`           // pages (or sheet pages) to export, e.g. "1-3,5"
+	ImageQuality        int    `json:"imageQuality"`        // JPEG quality 1-100
+	MaxImageResolution  int    `json:"maxImageResolution"`  // downsample images to this DPI (75-1200)
+	LosslessCompression bool   `json:"losslessCompression"` // keep images lossless instead of JPEG
+	EmbedStandardFonts  bool   `json:"embedStandardFonts"`  // also embed the 14 standard PDF fonts
+	ExportFormFields    *bool  `json:"exportFormFields"`    // keep form controls as PDF form fields
+	ExportBookmarks     *bool  `json:"exportBookmarks"`     // turn headings into PDF bookmarks
+	ExportNotes         bool   `json:"exportNotes"`         // export comments as PDF annotations
+	ExportHiddenSlides  bool   `json:"exportHiddenSlides"`  // include hidden presentation slides
+	SinglePageSheets    bool   `json:"singlePageSheets"`    // render each spreadsheet sheet on one page
+	UseTaggedPDF        bool   `json:"useTaggedPDF"`        // write a tagged (accessible) PDF
+	SkipEmptyPages      *bool  `json:"skipEmptyPages"`      // omit automatically inserted blank pages
+}
+
+// File extensions LibreOffice is allowed to convert.
+var convertToPDFExtensions = map[string]bool{
+	".doc": true, ".docx": true, ".docm": true, ".dot": true, ".dotx": true, ".odt": true, ".ott": true,
+	".rtf": true, ".txt": true, ".wpd": true, ".pages": true,
+	".xls": true, ".xlsx": true, ".xlsm": true, ".xlt": true, ".xltx": true, ".ods": true, ".ots": true, ".csv": true,
+	".ppt": true, ".pptx": true, ".pptm": true, ".pps": true, ".ppsx": true, ".odp": true, ".otp": true,
+	".odg": true, ".vsd": true, ".vsdx": true, ".svg": true,
+	".html": true, ".htm": true,
+}
+
+// Markup formats can reference remote resources, so they are converted by
+// utils.ConvertOffline rather than the networked pool. Each maps to the
+// import filter that reads it and the PDF export filter of that module.
+var offlineConvertFilters = map[string]struct{ infilter, export string }{
+	".html": {"HTML (StarWriter)", "writer_pdf_Export"},
+	".htm":  {"HTML (StarWriter)", "writer_pdf_Export"},
+	".svg":  {"SVG - Scalable Vector Graphics", "draw_pdf_Export"},
+}
+
+var pdfaVersions = map[string]int{"1b": 1, "2b": 2, "3b": 3}
+
+var exportPageRangePattern = regexp.MustCompile(`^\d+(-\d*)?(\s*[,;]\s*\d+(-\d*)?)*$`)
+
+// exportFilterOptions maps the request onto LibreOffice writer_pdf_Export
+// filter properties.
+func exportFilterOptions(req ConvertToPDFRequest) (map[string]string, error) {
+	opts := map[string]string{}
+
+	if req.PDFA != "" {
+		v, ok := pdfaVersions[strings.TrimPrefix(strings.ToLower(req.PDFA), "pdf/a-")]
+		if !ok {
+			return nil, fmt.Errorf("Invalid 'pdfa' value: %s (use 1b, 2b or 3b)", req.PDFA)
+		}
+		opts["SelectPdfVersion"] = strconv.Itoa(v)
+	}
+
+	if req.PageRange != "" {
+		pr := strings.TrimSpace(req.PageRange)
+		if !exportPageRangePattern.MatchString(pr) {
+			return nil, fmt.Errorf("Invalid 'pageRange': %s", req.PageRange)
+		}
+		opts["PageRange"] = strings.ReplaceAll(pr, " ", "")
+	}
+
+	if req.ImageQuality != 0 {
+		if req.ImageQuality < 1 || req.ImageQuality > 100 {
+			return nil, fmt.Errorf("Invalid 'imageQuality' value (1-100)")
+		}
+		opts["Quality"] = strconv.Itoa(req.ImageQuality)
+	}
+
+	if req.MaxImageResolution != 0 {
+		if req.MaxImageResolution < 75 || req.MaxImageResolution > 1200 {
+			return nil, fmt.Errorf("Invalid 'maxImageResolution' value (75-1200)")
+		}
+		opts["ReduceImageResolution"] = "true"
+		opts["MaxImageResolution"] = strconv.Itoa(req.MaxImageResolution)
+	}
+
+	flags := map[string]bool{
+		"UseLosslessCompression": req.LosslessCompression,
+		"EmbedStandardFonts":     req.EmbedStandardFonts,
+		"ExportNotes":            req.ExportNotes,
+		"ExportHiddenSlides":     req.ExportHiddenSlides,
+		"SinglePageSheets":       req.SinglePageSheets,
+		"UseTaggedPDF":           req.UseTaggedPDF,
+	}
+	for name, on := range flags {
+		if on {
+			opts[name] = "true"
+		}
+	}
+	if req.ExportFormFields != nil {
+		opts["ExportFormFields"] = strconv.FormatBool(*req.ExportFormFields)
+	}
+	if req.ExportBookmarks != nil {
+		opts["ExportBookmarks"] = strconv.FormatBool(*req.ExportBookmarks)
+	}
+	if req.SkipEmptyPages != nil {
+		opts["IsSkipEmptyPages"] = strconv.FormatBool(*req.SkipEmptyPages)
+	}
+
+	return opts, nil
+}
+
+// unoconvExportArgs passes export filter properties to unoconv as
+// -e Name=Value.
+func unoconvExportArgs(opts map[string]string) []string {
+	names := make([]string, 0, len(opts))
+	for name := range opts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		args = append(args, "-e", name+"="+opts[name])
+	}
+	return args
+}
+
+// sofficeConvertTo builds a soffice --convert-to value for the export
+// filter, with its properties in the typed JSON form soffice expects.
+func sofficeConvertTo(filter string, opts map[string]string) (string, error) {
+	if len(opts) == 0 {
+		return "pdf:" + filter, nil
+	}
+	type property struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	props := map[string]property{}
+	for name, value := range opts {
+		typ := "long"
+		if name == "PageRange" {
+			typ = "string"
+		} else if value == "true" || value == "false" {
+			typ = "boolean"
+		}
+		props[name] = property{Type: typ, Value: value}
+	}
+	b, err := json.Marshal(props)
+	if err != nil {
+		return "", err
+	}
+	return "pdf:" + filter + ":" + string(b), nil
+}
+
 func ConvertToPDFHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	fmt.Println("[ConvertToPDFHandler] ➜ Received request at", start.Format(time.RFC3339))
@@ -41,6 +190,26 @@ func ConvertToPDFHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fh := files[0]
+	ext := strings.ToLower(filepath.Ext(fh.Filename))
+	if !convertToPDFExtensions[ext] {
+		jsonConvertPDFError(w, fmt.Sprintf("Unsupported file type '%s'", ext), http.StatusBadRequest)
+		return
+	}
+
+	// Optional export options
+	var req ConvertToPDFRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonConvertPDFError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+	exportOpts, err := exportFilterOptions(req)
+	if err != nil {
+		jsonConvertPDFError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	file, err := fh.Open()
 	if err != nil {
 		jsonConvertPDFError(w, "Unable to read uploaded file", http.StatusBadRequest)
@@ -48,7 +217,6 @@ func ConvertToPDFHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	ext := filepath.Ext(fh.Filename)
 	inputPath := filepath.Join(os.TempDir(), fmt.Sprintf("uploaded-%d%s", time.Now().UnixNano(), ext))
 	outputPath := inputPath[:len(inputPath)-len(ext)] + ".pdf"
 
@@ -62,15 +230,31 @@ func ConvertToPDFHandler(w http.ResponseWriter, r *http.Request) {
 	defer tmpFile.Close()
 
 	io.Copy(tmpFile, file)
+	tmpFile.Close()
 
-	// Convert to PDF using unoconv
-	cmd := exec.Command("unoconv", "-f", "pdf", inputPath)
-	out, err := cmd.CombinedOutput()
+	// Convert to PDF using the LibreOffice worker pool, or a one-off offline
+	// LibreOffice for markup
+	var out []byte
+	if filters, ok := offlineConvertFilters[ext]; ok {
+		var convertTo string
+		convertTo, err = sofficeConvertTo(filters.export, exportOpts)
+		if err != nil {
+			jsonConvertPDFError(w, "Invalid export options", http.StatusBadRequest)
+			return
+		}
+		out, err = utils.ConvertOffline(r.Context(), inputPath, outputPath, convertTo, "--infilter="+filters.infilter)
+	} else {
+		out, err = utils.ConvertWithUnoconv(r.Context(), inputPath, outputPath, "pdf", unoconvExportArgs(exportOpts)...)
+	}
+	defer os.Remove(outputPath)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			jsonConvertPDFError(w, "Conversion timed out", http.StatusGatewayTimeout)
+			return
+		}
 		jsonConvertPDFError(w, "unoconv failed: "+string(out), http.StatusInternalServerError)
 		return
 	}
-	defer os.Remove(outputPath)
 
 	// Upload to R2
 	pdfFile, err := os.Open(outputPath)
@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/form"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+type FormFieldWidget struct {
+	Page int        `json:"page"`
+	Rect [4]float64 `json:"rect"` // llx, lly, urx, ury in points
+}
+
+type FormField struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Type    string            `json:"type"` // "text", "date", "checkbox", "radio", "combobox" or "listbox"
+	Value   interface{}       `json:"value"`
+	Options []string          `json:"options,omitempty"`
+	Locked  bool              `json:"locked"`
+	Pages   []int             `json:"pages"`
+	Widgets []FormFieldWidget `json:"widgets,omitempty"`
+}
+
+type FormFillRequest struct {
+	Values  map[string]interface{}   `json:"values"`  // single fill: field name (or id) to value
+	Records []map[string]interface{} `json:"records"` // batch fill: one value map per output
+	Merge   bool                     `json:"merge"`   // batch only: merge all filled copies into one PDF
+	Flatten bool                     `json:"flatten"` // burn field values into the page content
+}
+
+// fieldName builds the fully qualified name of a field from its T entries
+// up the Parent chain.
+func fieldName(xRefTable *model.XRefTable, d types.Dict) string {
+	var parts []string
+	for i := 0; d != nil && i < 32; i++ {
+		if t, err := xRefTable.DereferenceStringOrHexLiteral(d["T"], model.V10, nil); err == nil && t != "" {
+			parts = append([]string{t}, parts...)
+		}
+		parent, err := xRefTable.DereferenceDict(d["Parent"])
+		if err != nil {
+			break
+		}
+		d = parent
+	}
+	return strings.Join(parts, ".")
+}
+
+// formWidgets maps fully qualified field names to the page and rectangle of
+// each widget annotation representing them.
+func formWidgets(ctx *model.Context) map[string][]FormFieldWidget {
+	widgets := map[string][]FormFieldWidget{}
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil {
+			continue
+		}
+		annots, err := ctx.DereferenceArray(pageDict["Annots"])
+		if err != nil {
+			continue
+		}
+		for _, o := range annots {
+			d, err := ctx.DereferenceDict(o)
+			if err != nil || d == nil {
+				continue
+			}
+			if st := d.Subtype(); st == nil || *st != "Widget" {
+				continue
+			}
+			rect, err := ctx.DereferenceArray(d["Rect"])
+			if err != nil || len(rect) != 4 {
+				continue
+			}
+			var w FormFieldWidget
+			w.Page = pageNr
+			for i := range rect {
+				w.Rect[i], _ = ctx.DereferenceNumber(rect[i])
+			}
+			name := fieldName(ctx.XRefTable, d)
+			widgets[name] = append(widgets[name], w)
+		}
+	}
+	return widgets
+}
+
+// listFormFields returns every AcroForm field in the file with its current
+// value and widget locations.
+func listFormFields(path string) ([]FormField, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fg, err := api.ExportForm(f, filepath.Base(path), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		return nil, err
+	}
+	widgets := formWidgets(ctx)
+
+	var fields []FormField
+	for _, frm := range fg.Forms {
+		for _, tf := range frm.TextFields {
+			fields = append(fields, FormField{ID: tf.ID, Name: tf.Name, Type: "text", Value: tf.Value, Locked: tf.Locked, Pages: tf.Pages})
+		}
+		for _, df := range frm.DateFields {
+			fields = append(fields, FormField{ID: df.ID, Name: df.Name, Type: "date", Value: df.Value, Locked: df.Locked, Pages: df.Pages})
+		}
+		for _, cb := range frm.CheckBoxes {
+			fields = append(fields, FormField{ID: cb.ID, Name: cb.Name, Type: "checkbox", Value: cb.Value, Locked: cb.Locked, Pages: cb.Pages})
+		}
+		for _, rb := range frm.RadioButtonGroups {
+			fields = append(fields, FormField{ID: rb.ID, Name: rb.Name, Type: "radio", Value: rb.Value, Options: rb.Options, Locked: rb.Locked, Pages: rb.Pages})
+		}
+		for _, cb := range frm.ComboBoxes {
+			fields = append(fields, FormField{ID: cb.ID, Name: cb.Name, Type: "combobox", Value: cb.Value, Options: cb.Options, Locked: cb.Locked, Pages: cb.Pages})
+		}
+		for _, lb := range frm.ListBoxes {
+			fields = append(fields, FormField{ID: lb.ID, Name: lb.Name, Type: "listbox", Value: lb.Values, Options: lb.Options, Locked: lb.Locked, Pages: lb.Pages})
+		}
+	}
+	for i := range fields {
+		fields[i].Widgets = widgets[fields[i].Name]
+	}
+	return fields, nil
+}
+
+func formValueString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func formValueBool(v interface{}) bool {
+	switch val := v.(type) {
+	case bool:
+		return val
+	case string:
+		switch strings.ToLower(val) {
+		case "true", "yes", "on", "1", "x":
+			return true
+		}
+	case float64:
+		return val != 0
+	}
+	return false
+}
+
+func formValueList(v interface{}) []string {
+	if arr, ok := v.([]interface{}); ok {
+		var out []string
+		for _, e := range arr {
+			out = append(out, formValueString(e))
+		}
+		return out
+	}
+	if s := formValueString(v); s != "" {
+		return []string{s}
+	}
+	return nil
+}
+
+// applyFormValues writes values (keyed by field name or id) into frm and
+// returns the keys that matched no field.
+func applyFormValues(frm *form.Form, values map[string]interface{}) []string {
+	used := map[string]bool{}
+	lookup := func(id, name string) (interface{}, bool) {
+		if v, ok := values[name]; ok && name != "" {
+			used[name] = true
+			return v, true
+		}
+		if v, ok := values[id]; ok {
+			used[id] = true
+			return v, true
+		}
+		return nil, false
+	}
+
+	for _, tf := range frm.TextFields {
+		if v, ok := lookup(tf.ID, tf.Name); ok {
+			tf.Value = formValueString(v)
+		}
+	}
+	for _, df := range frm.DateFields {
+		if v, ok := lookup(df.ID, df.Name); ok {
+			df.Value = formValueString(v)
+		}
+	}
+	for _, cb := range frm.CheckBoxes {
+		if v, ok := lookup(cb.ID, cb.Name); ok {
+			cb.Value = formValueBool(v)
+		}
+	}
+	for _, rb := range frm.RadioButtonGroups {
+		if v, ok := lookup(rb.ID, rb.Name); ok {
+			rb.Value = formValueString(v)
+		}
+	}
+	for _, cb := range frm.ComboBoxes {
+		if v, ok := lookup(cb.ID, cb.Name); ok {
+			cb.Value = formValueString(v)
+		}
+	}
+	for _, lb := range frm.ListBoxes {
+		if v, ok := lookup(lb.ID, lb.Name); ok {
+			lb.Values = formValueList(v)
+		}
+	}
+
+	var unknown []string
+	for k := range values {
+		if !used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	return unknown
+}
+
+// fillForm fills the form in inPath with values and writes the result to
+// outPath, optionally flattening fields into static page content.
+func fillForm(ctx context.Context, inPath, outPath string, values map[string]interface{}, flatten bool) error {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fg, err := api.ExportForm(in, filepath.Base(inPath), model.NewDefaultConfiguration())
+	if err != nil {
+		return err
+	}
+	if len(fg.Forms) == 0 {
+		return fmt.Errorf("PDF has no form fields")
+	}
+
+	if unknown := applyFormValues(&fg.Forms[0], values); len(unknown) > 0 {
+		return fmt.Errorf("unknown form field(s): %s", strings.Join(unknown, ", "))
+	}
+
+	data, err := json.Marshal(form.FormGroup{Forms: fg.Forms[:1]})
+	if err != nil {
+		return err
+	}
+
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if err := api.FillForm(in, bytes.NewReader(data), out, model.NewDefaultConfiguration()); err != nil {
+		out.Close()
+		return err
+	}
+	out.Close()
+
+	if flatten {
+		if _, err := utils.RunQPDF(ctx, "--generate-appearances", "--flatten-annotations=all", "--replace-input", outPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func FormFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[FormFieldsHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "forms-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	fields, err := listFormFields(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read form fields: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Printf("[FormFieldsHandler] ✅ Found %d field(s)\n", len(fields))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"fields": fields,
+	})
+
+	fmt.Println("[FormFieldsHandler] ✅ Done in", time.Since(start))
+}
+
+func FormFillHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[FormFillHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req FormFillRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+	if len(req.Values) == 0 && len(req.Records) == 0 {
+		jsonError(w, "Provide 'values' or 'records'", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "forms-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	outputDir, err := os.MkdirTemp("", "formfill-")
+	if err != nil {
+		jsonError(w, "Failed to create output directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(outputDir)
+
+	// Single fill
+	if len(req.Records) == 0 {
+		outputPath := filepath.Join(outputDir, "filled.pdf")
+		if err := fillForm(r.Context(), inputPath, outputPath, req.Values, req.Flatten); err != nil {
+			jsonError(w, "Failed to fill form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		outFile, err := os.Open(outputPath)
+		if err != nil {
+			jsonError(w, "Failed to open filled PDF", http.StatusInternalServerError)
+			return
+		}
+		defer outFile.Close()
+
+		url, err := utils.UploadStreamToR2(fmt.Sprintf("forms/%d.pdf", time.Now().UnixNano()), outFile)
+		if err != nil {
+			jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"url": url})
+		fmt.Println("[FormFillHandler] ✅ Done in", time.Since(start))
+		return
+	}
+
+	// Batch fill: one PDF per record
+	var filled []string
+	for i, record := range req.Records {
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("filled-%04d.pdf", i+1))
+		if err := fillForm(r.Context(), inputPath, outputPath, record, req.Flatten); err != nil {
+			jsonError(w, fmt.Sprintf("Failed to fill record %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		filled = append(filled, outputPath)
+	}
+	fmt.Printf("[FormFillHandler] ✅ Filled %d record(s)\n", len(filled))
+
+	batch := time.Now().UnixNano()
+
+	if req.Merge {
+		mergedPath := filepath.Join(outputDir, "merged.pdf")
+		if err := api.MergeCreateFile(filled, mergedPath, false, nil); err != nil {
+			jsonError(w, "Failed to merge filled forms: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		mergedFile, err := os.Open(mergedPath)
+		if err != nil {
+			jsonError(w, "Failed to open merged PDF", http.StatusInternalServerError)
+			return
+		}
+		defer mergedFile.Close()
+
+		url, err := utils.UploadStreamToR2(fmt.Sprintf("forms/%d/merged.pdf", batch), mergedFile)
+		if err != nil {
+			jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"url": url})
+		fmt.Println("[FormFillHandler] ✅ Done in", time.Since(start))
+		return
+	}
+
+	var uploads []FileUpload
+	for _, path := range filled {
+		f, err := os.Open(path)
+		if err != nil {
+			jsonError(w, "Failed to open filled PDF", http.StatusInternalServerError)
+			return
+		}
+		url, err := utils.UploadStreamToR2(fmt.Sprintf("forms/%d/%s", batch, filepath.Base(path)), f)
+		f.Close()
+		if err != nil {
+			jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+			return
+		}
+		uploads = append(uploads, FileUpload{Filename: filepath.Base(path), URL: url})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": uploads,
+	})
+	fmt.Println("[FormFillHandler] ✅ Done in", time.Since(start))
+}
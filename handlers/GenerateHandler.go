@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/font"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// GenerateText is a line of text stamped onto the template for every row.
+type GenerateText struct {
+	Text     string  `json:"text"` // may contain {{column}} placeholders
+	Page     int     `json:"page"` // 0 stamps every page
+	X        float64 `json:"x"`    // points from the left edge of the page
+	Y        float64 `json:"y"`    // points from the bottom edge to the bottom of the text
+	FontSize int     `json:"fontSize"`
+	Font     string  `json:"font"`  // a PDF core font, default Helvetica
+	Color    string  `json:"color"` // #RRGGBB, default black
+	Align    string  `json:"align"` // "left" (default), "center" or "right": which part of the text sits at X
+}
+
+type GenerateRequest struct {
+	// Fields maps form field names to values, with {{column}} placeholders.
+	// Left empty, every column named like a form field fills that field.
+	Fields         map[string]string        `json:"fields"`
+	Texts          []GenerateText           `json:"texts"`
+	Records        []map[string]interface{} `json:"records"`        // used when no 'data' file is uploaded
+	FilenameColumn string                   `json:"filenameColumn"` // column naming each output file
+	Merge          bool                     `json:"merge"`          // return one PDF with every row instead of one per row
+	Flatten        bool                     `json:"flatten"`        // burn filled form fields into the page content
+}
+
+const (
+	maxGenerateRows         = 1000
+	defaultGenerateFontSize = 11
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// readDataset parses an uploaded CSV file, whose first row names the
+// columns, or a JSON array of objects.
+func readDataset(data []byte) ([]map[string]interface{}, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(trimmed, &rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON data: %v", err)
+		}
+		return rows, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV data: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+		if header[i] == "" {
+			return nil, fmt.Errorf("CSV column %d has no name", i+1)
+		}
+		if slices.Contains(header[:i], header[i]) {
+			return nil, fmt.Errorf("CSV column %q appears twice", header[i])
+		}
+	}
+	rows := make([]map[string]interface{}, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			row[name] = rec[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// expandPlaceholders replaces every {{column}} in s with the row's value.
+func expandPlaceholders(s string, row map[string]interface{}) string {
+	return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+		return formValueString(row[placeholderPattern.FindStringSubmatch(m)[1]])
+	})
+}
+
+// placeholderValue expands a field template. A template that is nothing
+// but one placeholder passes the value through unchanged, so that booleans
+// and lists still reach checkboxes and list boxes.
+func placeholderValue(s string, row map[string]interface{}) interface{} {
+	if m := placeholderPattern.FindStringSubmatch(s); m != nil && m[0] == strings.TrimSpace(s) {
+		return row[m[1]]
+	}
+	return expandPlaceholders(s, row)
+}
+
+// missingColumns returns the placeholders in templates that name no column
+// of the dataset.
+func missingColumns(templates []string, columns map[string]bool) []string {
+	var missing []string
+	for _, t := range templates {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(t, -1) {
+			if !columns[m[1]] && !slices.Contains(missing, m[1]) {
+				missing = append(missing, m[1])
+			}
+		}
+	}
+	return missing
+}
+
+// stamp builds the pdfcpu text stamp for one row. X is moved left by the
+// width of the text for centred and right-aligned text.
+func (t GenerateText) stamp(text string) (*model.Watermark, error) {
+	width := 0.
+	for _, line := range strings.Split(text, "\n") {
+		width = max(width, font.TextWidth(line, t.Font, t.FontSize))
+	}
+	x, align := t.X, "l"
+	switch t.Align {
+	case "center":
+		x, align = x-width/2, "c"
+	case "right":
+		x, align = x-width, "r"
+	}
+	desc := fmt.Sprintf("fontname:%s, points:%d, position:bl, offset:%s %s, align:%s, scalefactor:1 abs, rotation:0, fillcolor:%s",
+		t.Font, t.FontSize, strconv.FormatFloat(x, 'f', 2, 64), strconv.FormatFloat(t.Y, 'f', 2, 64), align, t.Color)
+	return api.TextWatermark(strings.ReplaceAll(text, "%", "%%"), desc, true, false, types.POINTS)
+}
+
+// stampTexts writes a copy of inPath with the row's texts stamped on and
+// returns its path, or inPath itself when there was nothing to stamp.
+func stampTexts(inPath, outPath string, texts []GenerateText, row map[string]interface{}, pageCount int) (string, error) {
+	stamps := map[int][]*model.Watermark{}
+	for _, t := range texts {
+		text := expandPlaceholders(t.Text, row)
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		wm, err := t.stamp(text)
+		if err != nil {
+			return "", err
+		}
+		if t.Page != 0 {
+			stamps[t.Page] = append(stamps[t.Page], wm)
+			continue
+		}
+		for p := 1; p <= pageCount; p++ {
+			stamps[p] = append(stamps[p], wm)
+		}
+	}
+	if len(stamps) == 0 {
+		return inPath, nil
+	}
+	if err := api.AddWatermarksSliceMapFile(inPath, outPath, stamps, nil); err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+// generateFilenames names each row's output after its value in column,
+// falling back to the row number and numbering repeats.
+func generateFilenames(rows []map[string]interface{}, column string) []string {
+	names := make([]string, len(rows))
+	used := map[string]bool{}
+	for i, row := range rows {
+		name := ""
+		if column != "" {
+			name = safeFilename(formValueString(row[column]))
+		}
+		if name == "" {
+			name = fmt.Sprintf("row-%04d", i+1)
+		}
+		names[i] = uniqueFilename(name+".pdf", "", used)
+	}
+	return names
+}
+
+func GenerateHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[GenerateHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	var req GenerateRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows := req.Records
+	if files := r.MultipartForm.File["data"]; len(files) > 0 {
+		f, err := files[0].Open()
+		if err != nil {
+			jsonError(w, "Failed to read 'data' file", http.StatusBadRequest)
+			return
+		}
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			jsonError(w, "Failed to read 'data' file", http.StatusBadRequest)
+			return
+		}
+		if rows, err = readDataset(buf.Bytes()); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(rows) == 0 {
+		jsonError(w, "Provide rows in a 'data' file (CSV or JSON) or in 'records'", http.StatusBadRequest)
+		return
+	}
+	if len(rows) > maxGenerateRows {
+		jsonError(w, fmt.Sprintf("At most %d rows are supported", maxGenerateRows), http.StatusBadRequest)
+		return
+	}
+
+	columns := map[string]bool{}
+	for _, row := range rows {
+		for k := range row {
+			columns[k] = true
+		}
+	}
+	if req.FilenameColumn != "" && !columns[req.FilenameColumn] {
+		jsonError(w, fmt.Sprintf("Unknown filename column %q", req.FilenameColumn), http.StatusBadRequest)
+		return
+	}
+	var templates []string
+	for _, v := range req.Fields {
+		templates = append(templates, v)
+	}
+	for i := range req.Texts {
+		t := &req.Texts[i]
+		if t.Page < 0 {
+			jsonError(w, fmt.Sprintf("Text %d has an invalid page", i+1), http.StatusBadRequest)
+			return
+		}
+		if t.FontSize <= 0 {
+			t.FontSize = defaultGenerateFontSize
+		}
+		if t.Font == "" {
+			t.Font = "Helvetica"
+		}
+		if !font.IsCoreFont(t.Font) {
+			jsonError(w, fmt.Sprintf("Text %d: unsupported font %q", i+1, t.Font), http.StatusBadRequest)
+			return
+		}
+		if t.Color == "" {
+			t.Color = "#000000"
+		}
+		if t.Align != "" && t.Align != "left" && t.Align != "center" && t.Align != "right" {
+			jsonError(w, fmt.Sprintf("Text %d: 'align' must be left, center or right", i+1), http.StatusBadRequest)
+			return
+		}
+		if _, err := t.stamp("x"); err != nil {
+			jsonError(w, fmt.Sprintf("Text %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+		templates = append(templates, t.Text)
+	}
+	if missing := missingColumns(templates, columns); len(missing) > 0 {
+		jsonError(w, "Unknown column(s) in placeholders: "+strings.Join(missing, ", "), http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "generate-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	pageCount, err := api.PageCountFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read template: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	for i, t := range req.Texts {
+		if t.Page > pageCount {
+			jsonError(w, fmt.Sprintf("Text %d is on page %d but the template has %d page(s)", i+1, t.Page, pageCount), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Without an explicit mapping, columns fill the form fields they are
+	// named after. A template without a form simply has no fields.
+	fields, _ := listFormFields(inputPath)
+	if len(req.Fields) > 0 && len(fields) == 0 {
+		jsonError(w, "Template has no form fields to fill", http.StatusBadRequest)
+		return
+	}
+	fieldMap := req.Fields
+	if len(fieldMap) == 0 {
+		fieldMap = map[string]string{}
+		for _, f := range fields {
+			if columns[f.Name] {
+				fieldMap[f.Name] = "{{" + f.Name + "}}"
+			}
+		}
+	}
+	if len(fieldMap) == 0 && len(req.Texts) == 0 {
+		jsonError(w, "Nothing to fill: no column matches a form field and no 'texts' are defined", http.StatusBadRequest)
+		return
+	}
+
+	outputDir, err := os.MkdirTemp("", "generate-")
+	if err != nil {
+		jsonError(w, "Failed to create output directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(outputDir)
+
+	names := generateFilenames(rows, req.FilenameColumn)
+	generated := make([]string, len(rows))
+	for i, row := range rows {
+		current := inputPath
+		if len(fieldMap) > 0 {
+			values := make(map[string]interface{}, len(fieldMap))
+			for name, tmpl := range fieldMap {
+				values[name] = placeholderValue(tmpl, row)
+			}
+			current = filepath.Join(outputDir, fmt.Sprintf("filled-%04d.pdf", i+1))
+			if err := fillForm(r.Context(), inputPath, current, values, req.Flatten); err != nil {
+				jsonError(w, fmt.Sprintf("Failed to generate row %d: %v", i+1, err), http.StatusBadRequest)
+				return
+			}
+		}
+		stamped := filepath.Join(outputDir, fmt.Sprintf("row-%04d.pdf", i+1))
+		if generated[i], err = stampTexts(current, stamped, req.Texts, row, pageCount); err != nil {
+			jsonError(w, fmt.Sprintf("Failed to generate row %d: %v", i+1, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	fmt.Printf("[GenerateHandler] ✅ Generated %d row(s)\n", len(generated))
+
+	batch := time.Now().UnixNano()
+
+	if req.Merge {
+		mergedPath := filepath.Join(outputDir, "merged.pdf")
+		if err := api.MergeCreateFile(generated, mergedPath, false, nil); err != nil {
+			jsonError(w, "Failed to merge generated files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		mergedFile, err := os.Open(mergedPath)
+		if err != nil {
+			jsonError(w, "Failed to open merged PDF", http.StatusInternalServerError)
+			return
+		}
+		defer mergedFile.Close()
+
+		url, err := utils.UploadStreamToR2(fmt.Sprintf("generate/%d/merged.pdf", batch), mergedFile)
+		if err != nil {
+			jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"url": url})
+		fmt.Println("[GenerateHandler] ✅ Done in", time.Since(start))
+		return
+	}
+
+	var uploads []FileUpload
+	for i, path := range generated {
+		f, err := os.Open(path)
+		if err != nil {
+			jsonError(w, "Failed to open generated PDF", http.StatusInternalServerError)
+			return
+		}
+		url, err := utils.UploadStreamToR2(fmt.Sprintf("generate/%d/%s", batch, names[i]), f)
+		f.Close()
+		if err != nil {
+			jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+			return
+		}
+		uploads = append(uploads, FileUpload{Filename: names[i], URL: url})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"files": uploads,
+	})
+	fmt.Println("[GenerateHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGenerateFilenames(t *testing.T) {
+	tests := []struct {
+		column string
+		values []interface{}
+		want   []string
+	}{
+		{"name", []interface{}{"a", "b"}, []string{"a.pdf", "b.pdf"}},
+		{"name", []interface{}{"x", "x", "x-2"}, []string{"x.pdf", "x-2.pdf", "x-2-2.pdf"}},
+		{"name", []interface{}{"x-2", "x", "x"}, []string{"x-2.pdf", "x.pdf", "x-3.pdf"}},
+		{"name", []interface{}{"", nil, "a/b"}, []string{"row-0001.pdf", "row-0002.pdf", "a_b.pdf"}},
+		{"name", []interface{}{float64(7), "7"}, []string{"7.pdf", "7-2.pdf"}},
+		{"", []interface{}{"a", "a"}, []string{"row-0001.pdf", "row-0002.pdf"}},
+	}
+	for _, tt := range tests {
+		rows := make([]map[string]interface{}, len(tt.values))
+		for i, v := range tt.values {
+			rows[i] = map[string]interface{}{"name": v}
+		}
+		got := generateFilenames(rows, tt.column)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("generateFilenames(%v, %q) = %v, want %v", tt.values, tt.column, got, tt.want)
+		}
+	}
+}
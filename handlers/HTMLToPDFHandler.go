@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/yuin/goldmark"
+)
+
+type HTMLMargins struct {
+	Top    string `json:"top"`
+	Right  string `json:"right"`
+	Bottom string `json:"bottom"`
+	Left   string `json:"left"`
+}
+
+type HTMLToPDFRequest struct {
+	Format          string      `json:"format"`          // "html" or "markdown", detected from the file extension if empty
+	PageSize        string      `json:"pageSize"`        // "A3", "A4", "A5", "Letter", "Legal" or "Tabloid"
+	Landscape       bool        `json:"landscape"`       // swap page width and height
+	Margins         HTMLMargins `json:"margins"`         // CSS lengths, e.g. "20mm", "1in"
+	HeaderTemplate  string      `json:"headerTemplate"`  // plain text, supports {{page}} and {{pages}}
+	FooterTemplate  string      `json:"footerTemplate"`  // plain text, supports {{page}} and {{pages}}
+	PrintBackground *bool       `json:"printBackground"` // defaults to true
+}
+
+// Page sizes in millimetres (portrait).
+var htmlPageSizes = map[string][2]float64{
+	"a3":      {297, 420},
+	"a4":      {210, 297},
+	"a5":      {148, 210},
+	"letter":  {215.9, 279.4},
+	"legal":   {215.9, 355.6},
+	"tabloid": {279.4, 431.8},
+}
+
+var cssLengthPattern = regexp.MustCompile(`^\d+(\.\d+)?(mm|cm|in|pt|px)$`)
+
+// Patterns used to keep the renderer offline: anything that would make
+// LibreOffice reach out to the network or outside the working directory is
+// stripped before conversion. The converter also runs without network
+// access, so these are a first line of defence rather than the only one.
+var (
+	htmlScriptPattern  = regexp.MustCompile(`(?is)<script\b.*?</script\s*>`)
+	htmlEmbedPattern   = regexp.MustCompile(`(?is)</?(script|iframe|object|embed|frame|frameset|base|applet)\b[^>]*>`)
+	htmlRefreshPattern = regexp.MustCompile(`(?i)<meta\b[^>]*http-equiv\s*=\s*["']?refresh[^>]*>`)
+	htmlLinkPattern    = regexp.MustCompile(`(?i)<link\b[^>]*\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]*))[^>]*>`)
+	htmlAttrPattern    = regexp.MustCompile(`(?i)\b(src|background|poster|data|lowsrc|dynsrc|xlink:href)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]*))`)
+	htmlSrcsetPattern  = regexp.MustCompile(`(?i)\b(?:image)?srcset\s*=\s*(?:"[^"]*"|'[^']*'|[^\s>]*)`)
+	svgRefTagPattern   = regexp.MustCompile(`(?is)<(?:image|use|feimage)\b[^>]*>`)
+	svgHrefPattern     = regexp.MustCompile(`(?i)\b(href)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]*))`)
+	cssURLPattern      = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)]*))\s*\)`)
+	cssImportPattern   = regexp.MustCompile(`(?i)@import\s+[^;]*;`)
+	headClosePattern   = regexp.MustCompile(`(?i)</head\s*>`)
+	bodyOpenPattern    = regexp.MustCompile(`(?i)<body\b`)
+	externalRefPattern = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9+.-]*:|/|\\|\.\./)`)
+)
+
+// isExternalRef reports whether a resource reference points anywhere other
+// than the working directory. Inline data: URIs are allowed.
+func isExternalRef(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(strings.ToLower(ref), "data:") {
+		return false
+	}
+	return externalRefPattern.MatchString(ref) || strings.Contains(ref, "../") || strings.Contains(ref, `..\`)
+}
+
+// submatchRef returns the quoted or unquoted value captured by one of the
+// attribute patterns above.
+func submatchRef(re *regexp.Regexp, match []byte) string {
+	m := re.FindSubmatch(match)
+	for _, g := range m[len(m)-3:] {
+		if len(g) > 0 {
+			return string(g)
+		}
+	}
+	return ""
+}
+
+// stripExternalAttrs empties every attribute matched by re whose value is
+// an external reference.
+func stripExternalAttrs(content []byte, re *regexp.Regexp) []byte {
+	return re.ReplaceAllFunc(content, func(m []byte) []byte {
+		if isExternalRef(submatchRef(re, m)) {
+			return re.ReplaceAll(m, []byte(`$1=""`))
+		}
+		return m
+	})
+}
+
+// sanitizeOffline removes external resource references from HTML or CSS so
+// only files shipped in the assets ZIP can be loaded.
+func sanitizeOffline(content []byte) []byte {
+	content = htmlScriptPattern.ReplaceAll(content, nil)
+	content = htmlEmbedPattern.ReplaceAll(content, nil)
+	content = htmlRefreshPattern.ReplaceAll(content, nil)
+	content = cssImportPattern.ReplaceAll(content, nil)
+	content = htmlLinkPattern.ReplaceAllFunc(content, func(m []byte) []byte {
+		if isExternalRef(submatchRef(htmlLinkPattern, m)) {
+			return nil
+		}
+		return m
+	})
+	// srcset holds a list of candidates, any of which may be fetched; src
+	// remains as the fallback.
+	content = htmlSrcsetPattern.ReplaceAll(content, nil)
+	content = stripExternalAttrs(content, htmlAttrPattern)
+	content = svgRefTagPattern.ReplaceAllFunc(content, func(m []byte) []byte {
+		return stripExternalAttrs(m, svgHrefPattern)
+	})
+	content = cssURLPattern.ReplaceAllFunc(content, func(m []byte) []byte {
+		if isExternalRef(submatchRef(cssURLPattern, m)) {
+			return []byte("url()")
+		}
+		return m
+	})
+	return content
+}
+
+// maxAssetsBytes caps the unpacked size of the assets ZIP, whatever its
+// entries claim about themselves.
+const maxAssetsBytes = 100 << 20
+
+// extractAssets unpacks the uploaded assets ZIP into dir, refusing entries
+// that would escape it, and sanitizes any HTML, CSS or SVG it contains.
+func extractAssets(zipPath, dir string) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var total int64
+
+	for _, f := range zr.File {
+		target := filepath.Join(dir, f.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal path in assets ZIP: %s", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return fmt.Errorf("unsupported entry in assets ZIP: %s", f.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxAssetsBytes-total+1))
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if total += int64(len(data)); total > maxAssetsBytes {
+			return fmt.Errorf("assets unpack to more than %d MB", maxAssetsBytes>>20)
+		}
+
+		switch strings.ToLower(filepath.Ext(f.Name)) {
+		case ".html", ".htm", ".css", ".svg":
+			data = sanitizeOffline(data)
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageStyle builds the CSS injected into the document for page size, margins
+// and background printing.
+func pageStyle(req HTMLToPDFRequest) (string, error) {
+	var b strings.Builder
+	b.WriteString("@page {")
+
+	if req.PageSize != "" {
+		dims, ok := htmlPageSizes[strings.ToLower(req.PageSize)]
+		if !ok {
+			return "", fmt.Errorf("Unsupported page size: %s", req.PageSize)
+		}
+		if req.Landscape {
+			dims[0], dims[1] = dims[1], dims[0]
+		}
+		fmt.Fprintf(&b, " size: %gmm %gmm;", dims[0], dims[1])
+	}
+
+	margins := map[string]string{
+		"margin-top":    req.Margins.Top,
+		"margin-right":  req.Margins.Right,
+		"margin-bottom": req.Margins.Bottom,
+		"margin-left":   req.Margins.Left,
+	}
+	for _, prop := range []string{"margin-top", "margin-right", "margin-bottom", "margin-left"} {
+		v := strings.TrimSpace(margins[prop])
+		if v == "" {
+			continue
+		}
+		if !cssLengthPattern.MatchString(v) {
+			return "", fmt.Errorf("Invalid %s: %s", prop, v)
+		}
+		fmt.Fprintf(&b, " %s: %s;", prop, v)
+	}
+	b.WriteString(" }\n")
+
+	if req.PrintBackground != nil && !*req.PrintBackground {
+		b.WriteString("html, body, div, table, td, th, p, span, section, header, footer { background: transparent; background-image: none; }\n")
+	}
+	return b.String(), nil
+}
+
+// injectStyle places css into the document head, creating one if needed.
+func injectStyle(doc []byte, css string) []byte {
+	tag := "<style type=\"text/css\">\n" + css + "</style>\n"
+	if loc := headClosePattern.FindIndex(doc); loc != nil {
+		return []byte(string(doc[:loc[0]]) + tag + string(doc[loc[0]:]))
+	}
+	if loc := bodyOpenPattern.FindIndex(doc); loc != nil {
+		return []byte(string(doc[:loc[0]]) + "<head>" + tag + "</head>" + string(doc[loc[0]:]))
+	}
+	return []byte("<html><head>" + tag + "</head><body>\n" + string(doc) + "</body></html>\n")
+}
+
+// stampTemplate turns a header/footer template into a pdfcpu text stamp.
+func stampTemplate(text, position string, offsetY int) (*model.Watermark, error) {
+	text = strings.ReplaceAll(text, "%", "%%")
+	text = strings.ReplaceAll(text, "{{pages}}", "%P")
+	text = strings.ReplaceAll(text, "{{page}}", "%p")
+	desc := fmt.Sprintf("fontname:Helvetica, points:9, position:%s, offset:0 %d, scalefactor:1 abs, rotation:0, fillcolor:#000000", position, offsetY)
+	return api.TextWatermark(text, desc, true, false, types.POINTS)
+}
+
+func HTMLToPDFHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[HTMLToPDFHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	var req HTMLToPDFRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		jsonError(w, "Missing 'file' field", http.StatusBadRequest)
+		return
+	}
+	fh := files[0]
+
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(fh.Filename)) {
+		case ".md", ".markdown":
+			format = "markdown"
+		default:
+			format = "html"
+		}
+	}
+	if format == "md" {
+		format = "markdown"
+	}
+	if format != "html" && format != "markdown" {
+		jsonError(w, "Invalid format. Use 'html' or 'markdown'", http.StatusBadRequest)
+		return
+	}
+
+	css, err := pageStyle(req)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		jsonError(w, "Unable to read uploaded file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	source, err := io.ReadAll(file)
+	if err != nil {
+		jsonError(w, "Failed to read uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	workDir, err := os.MkdirTemp("", "html2pdf-")
+	if err != nil {
+		jsonError(w, "Failed to create working directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	// Unpack optional assets (CSS, fonts, images) next to the document so
+	// relative references resolve without any network access.
+	if assets := r.MultipartForm.File["assets"]; len(assets) > 0 {
+		af, err := assets[0].Open()
+		if err != nil {
+			jsonError(w, "Unable to read uploaded assets", http.StatusBadRequest)
+			return
+		}
+		zipPath := filepath.Join(workDir, "assets.zip")
+		zf, err := os.Create(zipPath)
+		if err != nil {
+			af.Close()
+			jsonError(w, "Failed to save uploaded assets", http.StatusInternalServerError)
+			return
+		}
+		_, err = io.Copy(zf, af)
+		zf.Close()
+		af.Close()
+		if err != nil {
+			jsonError(w, "Failed to save uploaded assets", http.StatusInternalServerError)
+			return
+		}
+		if err := extractAssets(zipPath, workDir); err != nil {
+			jsonError(w, "Invalid assets ZIP: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		os.Remove(zipPath)
+		fmt.Println("[HTMLToPDFHandler] ✅ Assets extracted to:", workDir)
+	}
+
+	if format == "markdown" {
+		var buf bytes.Buffer
+		if err := goldmark.Convert(source, &buf); err != nil {
+			jsonError(w, "Failed to render Markdown: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		source = []byte("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"></head><body>\n" + buf.String() + "</body></html>\n")
+	}
+
+	source = injectStyle(sanitizeOffline(source), css)
+
+	inputPath := filepath.Join(workDir, fmt.Sprintf("document-%d.html", time.Now().UnixNano()))
+	if err := os.WriteFile(inputPath, source, 0644); err != nil {
+		jsonError(w, "Failed to save document", http.StatusInternalServerError)
+		return
+	}
+	outputPath := strings.TrimSuffix(inputPath, ".html") + ".pdf"
+
+	out, err := utils.ConvertOffline(r.Context(), inputPath, outputPath, "pdf:writer_pdf_Export", "--infilter=HTML (StarWriter)")
+	if err != nil {
+		jsonError(w, "LibreOffice failed: "+string(out), http.StatusInternalServerError)
+		return
+	}
+	fmt.Println("[HTMLToPDFHandler] ✅ Rendered PDF:", outputPath)
+
+	if req.HeaderTemplate != "" || req.FooterTemplate != "" {
+		stamped := strings.TrimSuffix(outputPath, ".pdf") + "-stamped.pdf"
+		conf := model.NewDefaultConfiguration()
+		current := outputPath
+
+		templates := []struct {
+			text     string
+			position string
+			offsetY  int
+		}{
+			{req.HeaderTemplate, "tc", -18},
+			{req.FooterTemplate, "bc", 18},
+		}
+		for _, t := range templates {
+			if t.text == "" {
+				continue
+			}
+			wm, err := stampTemplate(t.text, t.position, t.offsetY)
+			if err != nil {
+				jsonError(w, "Invalid header/footer template: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := api.AddWatermarksFile(current, stamped, nil, wm, conf); err != nil {
+				jsonError(w, "Failed to apply header/footer: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			current, stamped = stamped, current
+		}
+		outputPath = current
+		fmt.Println("[HTMLToPDFHandler] ✅ Header/footer applied")
+	}
+
+	pdfFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open rendered PDF", http.StatusInternalServerError)
+		return
+	}
+	defer pdfFile.Close()
+
+	uploadKey := fmt.Sprintf("html-to-pdf/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, pdfFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": url,
+	})
+
+	fmt.Println("[HTMLToPDFHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/pageselect"
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+type ImposeRequest struct {
+	Mode      string               `json:"mode"`      // "nup", "booklet" or "poster"
+	Pages     pageselect.Selection `json:"pages"`     // source pages, default all
+	PaperSize string               `json:"paperSize"` // output sheet, or poster tile, e.g. "A4", "A3L", "Letter"; default A4
+	N         int                  `json:"n"`         // nup: pages per sheet, one of 2, 3, 4, 6, 8, 9, 12, 16
+	Order     string               `json:"order"`     // nup: "rd" (right then down, default), "dr", "ld" or "dl"
+	Border    bool                 `json:"border"`    // nup: frame each page
+	Margin    float64              `json:"margin"`    // nup: space around each page in points
+	Binding   string               `json:"binding"`   // booklet: "long" (default) or "short" edge
+	Guides    bool                 `json:"guides"`    // booklet: draw fold and cut lines
+	Creep     float64              `json:"creep"`     // booklet: shift of the innermost pages towards the spine, in points
+	Scale     float64              `json:"scale"`     // poster: enlargement factor, at least 1
+	Overlap   float64              `json:"overlap"`   // poster: glue area shared by neighbouring tiles, in points
+	CropMarks bool                 `json:"cropMarks"` // poster: draw the cut line of each tile
+}
+
+var nupOrders = map[string]bool{"rd": true, "dr": true, "ld": true, "dl": true}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// applyCreep compensates for saddle-stitch creep. The sheets nested inside
+// a folded booklet stick out further at the fore edge the deeper they sit
+// and lose more to trimming, so the content of each page is shifted towards
+// the spine in proportion to its sheet's depth: not at all on the outer
+// sheet and by creep points on the innermost one. The shift is made by
+// moving the crop box, which the imposition uses to place each page, and
+// the media box grows to keep the moved crop box inside it.
+func applyCreep(inPath, outPath string, creep float64) error {
+	ctx, err := api.ReadContextFile(inPath)
+	if err != nil {
+		return err
+	}
+
+	padded := (ctx.PageCount + 3) / 4 * 4
+	sheets := padded / 4
+	if sheets < 2 {
+		return api.WriteContextFile(ctx, outPath)
+	}
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, inh, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil || inh == nil {
+			return fmt.Errorf("cannot read page %d", pageNr)
+		}
+		box := inh.MediaBox
+		if inh.CropBox != nil {
+			box = inh.CropBox
+		}
+		if box == nil {
+			return fmt.Errorf("page %d has no media box", pageNr)
+		}
+
+		sheet := min(pageNr-1, padded-pageNr) / 2
+		shift := creep * float64(sheet) / float64(sheets-1)
+		// Odd pages sit right of the spine: moving the crop box right moves
+		// their content left, towards the fold. Even pages go the other way.
+		if pageNr%2 == 0 {
+			shift = -shift
+		}
+		crop := types.NewRectangle(box.LL.X+shift, box.LL.Y, box.UR.X+shift, box.UR.Y)
+		pageDict["CropBox"] = crop.Array()
+		// Viewers clip the crop box to the media box, so widen the media
+		// box on the side the crop box moved towards.
+		if media := inh.MediaBox; media != nil {
+			pageDict["MediaBox"] = types.NewRectangle(
+				math.Min(media.LL.X, crop.LL.X), media.LL.Y,
+				math.Max(media.UR.X, crop.UR.X), media.UR.Y,
+			).Array()
+		}
+	}
+	return api.WriteContextFile(ctx, outPath)
+}
+
+// posterConfig turns the poster settings into a pdfcpu cut configuration.
+func posterConfig(req ImposeRequest) (*model.Cut, error) {
+	desc := fmt.Sprintf("formsize:%s, scalefactor:%s, margin:%s, border:%s",
+		req.PaperSize,
+		strconv.FormatFloat(req.Scale, 'f', -1, 64),
+		strconv.FormatFloat(req.Overlap, 'f', -1, 64),
+		onOff(req.CropMarks),
+	)
+	return pdfcpu.ParseCutConfigForPoster(desc, types.POINTS)
+}
+
+// posterTiles tiles every page of inPath and collects the tiles, in page
+// order, into outPath.
+func posterTiles(inPath, outPath, workDir string, cut *model.Cut) error {
+	tileDir := filepath.Join(workDir, "tiles")
+	if err := os.Mkdir(tileDir, 0755); err != nil {
+		return err
+	}
+	if err := api.PosterFile(inPath, tileDir, "poster", nil, cut, nil); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(tileDir)
+	if err != nil {
+		return err
+	}
+	pageOf := func(name string) int {
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "poster_page_"), ".pdf"))
+		return n
+	}
+	sort.Slice(entries, func(i, j int) bool { return pageOf(entries[i].Name()) < pageOf(entries[j].Name()) })
+
+	var tiles []string
+	for _, e := range entries {
+		tiles = append(tiles, filepath.Join(tileDir, e.Name()))
+	}
+	if len(tiles) == 0 {
+		return fmt.Errorf("no poster tiles were produced")
+	}
+	if len(tiles) == 1 {
+		return os.Rename(tiles[0], outPath)
+	}
+	return api.MergeCreateFile(tiles, outPath, false, nil)
+}
+
+func ImposeHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[ImposeHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req ImposeRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+	if req.PaperSize == "" {
+		req.PaperSize = "A4"
+	}
+	if _, _, err := types.ParsePageFormat(req.PaperSize); err != nil {
+		jsonError(w, fmt.Sprintf("Unknown paper size %q", req.PaperSize), http.StatusBadRequest)
+		return
+	}
+
+	switch req.Mode {
+	case "nup":
+		if req.Order == "" {
+			req.Order = "rd"
+		}
+		if !nupOrders[req.Order] {
+			jsonError(w, "Invalid order. Use 'rd', 'dr', 'ld' or 'dl'", http.StatusBadRequest)
+			return
+		}
+		if req.Margin < 0 {
+			jsonError(w, "'margin' must not be negative", http.StatusBadRequest)
+			return
+		}
+	case "booklet":
+		if req.Binding == "" {
+			req.Binding = "long"
+		}
+		if req.Binding != "long" && req.Binding != "short" {
+			jsonError(w, "Invalid binding. Use 'long' or 'short'", http.StatusBadRequest)
+			return
+		}
+		if req.Creep < 0 {
+			jsonError(w, "'creep' must not be negative", http.StatusBadRequest)
+			return
+		}
+	case "poster":
+		if req.Scale == 0 {
+			req.Scale = 1
+		}
+		if req.Scale < 1 {
+			jsonError(w, "'scale' must be at least 1", http.StatusBadRequest)
+			return
+		}
+		if req.Overlap < 0 {
+			jsonError(w, "'overlap' must not be negative", http.StatusBadRequest)
+			return
+		}
+	default:
+		jsonError(w, "Invalid mode. Use 'nup', 'booklet' or 'poster'", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "impose-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	workDir, err := os.MkdirTemp("", "impose-")
+	if err != nil {
+		jsonError(w, "Failed to create temp dir", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	// Bring the selected pages together first so that the imposition sees
+	// them in the requested order.
+	source := inputPath
+	if !req.Pages.IsEmpty() {
+		pageCount, err := api.PageCountFile(inputPath)
+		if err != nil {
+			jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		pages, err := req.Pages.Pages(pageCount)
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source = filepath.Join(workDir, "selected.pdf")
+		if err := api.CollectFile(inputPath, source, pageselect.Format(pages), nil); err != nil {
+			jsonError(w, "Failed to select pages: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	outputPath := filepath.Join(workDir, "imposed.pdf")
+	switch req.Mode {
+	case "nup":
+		desc := fmt.Sprintf("formsize:%s, orientation:%s, border:%s, margin:%s",
+			req.PaperSize, req.Order, onOff(req.Border), strconv.FormatFloat(req.Margin, 'f', -1, 64))
+		nup, cfgErr := pdfcpu.PDFNUpConfig(req.N, desc, nil)
+		if cfgErr != nil {
+			jsonError(w, "Invalid n-up settings: "+cfgErr.Error(), http.StatusBadRequest)
+			return
+		}
+		err = api.NUpFile([]string{source}, outputPath, nil, nup, nil)
+
+	case "booklet":
+		if req.Creep > 0 {
+			crept := filepath.Join(workDir, "creep.pdf")
+			if err := applyCreep(source, crept, req.Creep); err != nil {
+				jsonError(w, "Failed to apply creep: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			source = crept
+		}
+		desc := fmt.Sprintf("formsize:%s, binding:%s, guides:%s", req.PaperSize, req.Binding, onOff(req.Guides))
+		nup, cfgErr := pdfcpu.PDFBookletConfig(2, desc, nil)
+		if cfgErr != nil {
+			jsonError(w, "Invalid booklet settings: "+cfgErr.Error(), http.StatusBadRequest)
+			return
+		}
+		err = api.BookletFile([]string{source}, outputPath, nil, nup, nil)
+
+	case "poster":
+		cut, cfgErr := posterConfig(req)
+		if cfgErr != nil {
+			jsonError(w, "Invalid poster settings: "+cfgErr.Error(), http.StatusBadRequest)
+			return
+		}
+		err = posterTiles(source, outputPath, workDir, cut)
+	}
+	if err != nil {
+		fmt.Println("[ImposeHandler] ❌ Imposition failed:", err)
+		jsonError(w, "Failed to impose PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sheets, err := api.PageCountFile(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to read imposed PDF", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("[ImposeHandler] ✅ %s imposition: %d sheet(s)\n", req.Mode, sheets)
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open imposed PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("imposed/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":    url,
+		"sheets": sheets,
+	})
+
+	fmt.Println("[ImposeHandler] ✅ Done in", time.Since(start))
+}
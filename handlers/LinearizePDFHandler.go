@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+)
+
+func LinearizeHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[LinearizeHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		jsonError(w, "Missing 'file' field", http.StatusBadRequest)
+		return
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		jsonError(w, "Unable to read uploaded file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	inputTmp, err := os.CreateTemp("", "linearize-in-*.pdf")
+	if err != nil {
+		jsonError(w, "Failed to create temp file", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(inputTmp.Name())
+
+	if _, err := io.Copy(inputTmp, file); err != nil {
+		inputTmp.Close()
+		jsonError(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	inputTmp.Close()
+
+	outputPath := inputTmp.Name() + "-linearized.pdf"
+	defer os.Remove(outputPath)
+
+	warnings, err := utils.RunQPDF(r.Context(), "--linearize", inputTmp.Name(), outputPath)
+	if err != nil {
+		fmt.Println("[LinearizeHandler] ❌ Linearization failed:", err)
+		jsonError(w, "Failed to linearize PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, warning := range warnings {
+		fmt.Println("[LinearizeHandler] ⚠️ ", warning)
+	}
+	fmt.Println("[LinearizeHandler] ✅ Linearized:", outputPath)
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open linearized PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("linearized/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": url,
+	})
+
+	fmt.Println("[LinearizeHandler] ✅ Done in", time.Since(start))
+}
@@ -1,17 +1,37 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Lucifer7355/PDF/pageselect"
 	"github.com/Lucifer7355/PDF/utils"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
+type MergeRequest struct {
+	AutoRepair    bool               `json:"autoRepair"`    // repair inputs that fail to parse instead of failing the merge
+	Bookmarks     bool               `json:"bookmarks"`     // add one top-level bookmark per input file
+	Files         []MergeFileOptions `json:"files"`         // per uploaded file, in upload order
+	Order         []int              `json:"order"`         // merge order as 1-based upload positions, default upload order
+	Interleave    bool               `json:"interleave"`    // alternate the pages of exactly two files, e.g. duplex fronts and backs
+	ReverseSecond bool               `json:"reverseSecond"` // interleave: take the second file's pages back to front
+	DuplexBlanks  bool               `json:"duplexBlanks"`  // add a blank page after every document with an odd page count
+	Dividers      bool               `json:"dividers"`      // insert a blank divider page between documents
+}
+
+type MergeFileOptions struct {
+	Pages pageselect.Selection `json:"pages"` // pages to take from this file, default all
+}
+
 func MergeHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	fmt.Println("[MergeHandler] ➜ Received request at", start.Format(time.RFC3339))
@@ -23,6 +43,15 @@ func MergeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var req MergeRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			fmt.Println("[MergeHandler] ❌ Invalid meta:", err)
+			http.Error(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+
 	files := r.MultipartForm.File["files"]
 	if len(files) < 2 {
 		fmt.Println("[MergeHandler] ❌ Less than 2 files uploaded")
@@ -30,7 +59,26 @@ func MergeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var inputPaths []string
+	if req.Interleave && len(files) != 2 {
+		http.Error(w, "Interleave needs exactly 2 PDF files", http.StatusBadRequest)
+		return
+	}
+	if req.Interleave && (req.Bookmarks || req.Dividers) {
+		http.Error(w, "Interleave cannot be combined with 'bookmarks' or 'dividers'", http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) > len(files) {
+		http.Error(w, fmt.Sprintf("'files' has %d entries but only %d files were uploaded", len(req.Files), len(files)), http.StatusBadRequest)
+		return
+	}
+	order, err := mergeOrder(req.Order, len(files))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inputPaths := make([]string, len(files))
+	var repaired []string
 	for i, fh := range files {
 		file, err := fh.Open()
 		if err != nil {
@@ -54,14 +102,69 @@ func MergeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		tmp.Close()
 
-		inputPaths = append(inputPaths, tmp.Name())
-		fmt.Printf("[MergeHandler] ✅ File %d saved to: %s\n", i+1, tmp.Name())
+		inputPath := tmp.Name()
+		if req.AutoRepair && api.ValidateFile(inputPath, relaxedConfig()) != nil {
+			repairedPath := inputPath + "-repaired.pdf"
+			defer os.Remove(repairedPath)
+
+			report, err := repairPDF(r.Context(), inputPath, repairedPath)
+			if err != nil {
+				fmt.Printf("[MergeHandler] ❌ Could not repair file %d: %v\n", i+1, err)
+				http.Error(w, fmt.Sprintf("File %s is damaged and could not be repaired", fh.Filename), http.StatusUnprocessableEntity)
+				return
+			}
+			fmt.Printf("[MergeHandler] 🔧 File %d repaired with %s\n", i+1, report.Method)
+			repaired = append(repaired, fh.Filename)
+			inputPath = repairedPath
+		}
+
+		inputPaths[i] = inputPath
+		fmt.Printf("[MergeHandler] ✅ File %d saved to: %s\n", i+1, inputPath)
+	}
+
+	workDir, err := os.MkdirTemp("", "merge-work-")
+	if err != nil {
+		http.Error(w, "Failed to create temp dir", http.StatusInternalServerError)
+		return
 	}
+	defer os.RemoveAll(workDir)
 
-	out := filepath.Join(os.TempDir(), "merged.pdf")
-	defer os.Remove(out)
+	var mergePaths, mergeNames []string
+	for n, idx := range order {
+		if inputPaths[idx] == "" {
+			http.Error(w, fmt.Sprintf("File %s could not be read", files[idx].Filename), http.StatusBadRequest)
+			return
+		}
+		var opts MergeFileOptions
+		if idx < len(req.Files) {
+			opts = req.Files[idx]
+		}
+		reverse := req.Interleave && req.ReverseSecond && n == 1
+		padOdd := req.DuplexBlanks && !req.Interleave
 
-	err = api.MergeCreateFile(inputPaths, out, false, nil)
+		prepared, err := prepareMergeInput(inputPaths[idx], filepath.Join(workDir, fmt.Sprintf("input-%d.pdf", n)), opts.Pages, reverse, padOdd)
+		if err != nil {
+			fmt.Printf("[MergeHandler] ❌ Could not prepare %s: %v\n", files[idx].Filename, err)
+			http.Error(w, fmt.Sprintf("File %s: %v", files[idx].Filename, err), http.StatusBadRequest)
+			return
+		}
+		mergePaths = append(mergePaths, prepared)
+		mergeNames = append(mergeNames, files[idx].Filename)
+	}
+
+	out := filepath.Join(workDir, "merged.pdf")
+	if req.Interleave {
+		err = api.MergeCreateZipFile(mergePaths[0], mergePaths[1], out, nil)
+		if err == nil && req.DuplexBlanks {
+			paddedPath := filepath.Join(workDir, "merged-padded.pdf")
+			var padded bool
+			if padded, err = padToEvenPages(out, paddedPath); padded {
+				out = paddedPath
+			}
+		}
+	} else {
+		err = api.MergeCreateFile(mergePaths, out, req.Dividers, nil)
+	}
 	if err != nil {
 		fmt.Println("[MergeHandler] ❌ Merge failed:", err)
 		http.Error(w, "Failed to merge PDFs", http.StatusInternalServerError)
@@ -69,6 +172,22 @@ func MergeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Println("[MergeHandler] ✅ Merge successful. Output file:", out)
 
+	if req.Bookmarks {
+		withBookmarks := out + "-bookmarks.pdf"
+		defer os.Remove(withBookmarks)
+
+		nodes, err := fileBookmarks(mergePaths, mergeNames, req.Dividers)
+		if err == nil {
+			err = writeBookmarks(out, withBookmarks, nodes)
+		}
+		if err != nil {
+			fmt.Println("[MergeHandler] ❌ Failed to add bookmarks:", err)
+			http.Error(w, "Failed to add bookmarks", http.StatusInternalServerError)
+			return
+		}
+		out = withBookmarks
+	}
+
 	// Open file for streaming
 	f, err := os.Open(out)
 	if err != nil {
@@ -89,7 +208,120 @@ func MergeHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"url":"` + url + `"}`))
+	resp := map[string]interface{}{"url": url}
+	if len(repaired) > 0 {
+		resp["repaired"] = repaired
+	}
+	json.NewEncoder(w).Encode(resp)
 
 	fmt.Println("[MergeHandler] ✅ Response sent in", time.Since(start))
 }
+
+// fileBookmarks builds one top-level bookmark per merged file, titled after
+// the uploaded filename. Each file's own outline is kept underneath it when
+// it is well formed. pdfcpu puts divider pages ahead of every file but the
+// first.
+func fileBookmarks(paths, names []string, dividers bool) ([]BookmarkNode, error) {
+	var nodes []BookmarkNode
+	offset := 0
+	for i, path := range paths {
+		pageCount, err := api.PageCountFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if dividers && i > 0 {
+			offset++
+		}
+
+		node := BookmarkNode{
+			Title: strings.TrimSuffix(names[i], filepath.Ext(names[i])),
+			Page:  offset + 1,
+		}
+		if kids, err := readBookmarks(path); err == nil && validateBookmarks(kids, 1, pageCount) == nil {
+			node.Children = offsetBookmarks(kids, offset)
+		}
+
+		nodes = append(nodes, node)
+		offset += pageCount
+	}
+	return nodes, nil
+}
+
+// mergeOrder turns the 1-based upload positions in order into 0-based
+// indexes, checking that every uploaded file appears exactly once.
+func mergeOrder(order []int, fileCount int) ([]int, error) {
+	if len(order) == 0 {
+		idx := make([]int, fileCount)
+		for i := range idx {
+			idx[i] = i
+		}
+		return idx, nil
+	}
+	if len(order) != fileCount {
+		return nil, fmt.Errorf("'order' must list all %d uploaded files", fileCount)
+	}
+
+	seen := make(map[int]bool)
+	idx := make([]int, 0, fileCount)
+	for _, pos := range order {
+		if pos < 1 || pos > fileCount {
+			return nil, fmt.Errorf("'order' entry %d is out of range (1-%d)", pos, fileCount)
+		}
+		if seen[pos] {
+			return nil, fmt.Errorf("'order' lists file %d twice", pos)
+		}
+		seen[pos] = true
+		idx = append(idx, pos-1)
+	}
+	return idx, nil
+}
+
+// prepareMergeInput applies the per-file options before merging: the page
+// selection, reversal and padding to an even page count. The input is
+// returned unchanged when there is nothing to do.
+func prepareMergeInput(inPath, outPath string, selection pageselect.Selection, reverse, padOdd bool) (string, error) {
+	pageCount, err := api.PageCountFile(inPath)
+	if err != nil {
+		return "", err
+	}
+	pages, err := selection.PagesOrAll(pageCount)
+	if err != nil {
+		return "", err
+	}
+	if reverse {
+		slices.Reverse(pages)
+	}
+
+	path := inPath
+	if !slices.Equal(pages, pageselect.Range(1, pageCount)) {
+		if err := api.CollectFile(path, outPath, pageselect.Format(pages), nil); err != nil {
+			return "", err
+		}
+		path = outPath
+	}
+
+	if padOdd {
+		paddedPath := strings.TrimSuffix(outPath, ".pdf") + "-padded.pdf"
+		padded, err := padToEvenPages(path, paddedPath)
+		if err != nil {
+			return "", err
+		}
+		if padded {
+			path = paddedPath
+		}
+	}
+	return path, nil
+}
+
+// padToEvenPages writes inPath to outPath with a blank page, sized like the
+// last page, appended when it has an odd number of pages, so that the next
+// document starts on a new sheet when printed duplex. It reports whether
+// outPath was written.
+func padToEvenPages(inPath, outPath string) (bool, error) {
+	pageCount, err := api.PageCountFile(inPath)
+	if err != nil || pageCount%2 == 0 {
+		return false, err
+	}
+	err = api.InsertPagesFile(inPath, outPath, []string{strconv.Itoa(pageCount)}, false, nil, nil)
+	return err == nil, err
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/pageselect"
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+type OCRRequest struct {
+	Pages     pageselect.Selection `json:"pages"`     // default all
+	Languages []string             `json:"languages"` // tesseract language codes, default ["eng"]
+	DPI       int                  `json:"dpi"`       // rendering resolution, default 300
+	Deskew    bool                 `json:"deskew"`    // straighten skewed scans; such pages are replaced by the straightened image
+	SkipText  bool                 `json:"skipText"`  // leave pages that already have text alone
+}
+
+type OCRPageReport struct {
+	Page       int     `json:"page"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	Words      int     `json:"words"`
+	Confidence float64 `json:"confidence"`          // mean word confidence, 0 to 100
+	SkewAngle  float64 `json:"skewAngle,omitempty"` // degrees the page was turned by deskewing
+}
+
+const (
+	defaultOCRDPI = 300
+	minOCRDPI     = 72
+	maxOCRDPI     = 600
+	// Skew beyond maxDeskewDegrees is more likely a layout feature than a
+	// crooked scan; below minDeskewDegrees resampling costs more than it
+	// helps recognition.
+	maxDeskewDegrees = 10
+	minDeskewDegrees = 0.1
+)
+
+// deskewPage straightens a rendered page in place and turns it into an
+// image-only PDF page of the displayed page size. It returns the angle
+// corrected and the PDF, or 0 and "" when the page is straight enough.
+func deskewPage(pngPath string, width, height float64) (float64, string, error) {
+	f, err := os.Open(pngPath)
+	if err != nil {
+		return 0, "", err
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return 0, "", err
+	}
+
+	angle := utils.SkewAngle(img, maxDeskewDegrees)
+	if math.Abs(angle) < minDeskewDegrees {
+		return 0, "", nil
+	}
+
+	out, err := os.Create(pngPath)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := png.Encode(out, utils.RotateImage(img, angle)); err != nil {
+		out.Close()
+		return 0, "", err
+	}
+	out.Close()
+
+	pdfPath := strings.TrimSuffix(pngPath, ".png") + "-image.pdf"
+	if err := imagePagePDF(pngPath, pdfPath, width, height); err != nil {
+		return 0, "", err
+	}
+	return angle, pdfPath, nil
+}
+
+// qpdfPageArgs lists the given pages of path, in order, for qpdf --pages,
+// taking the pages in replace from their own single-page files instead.
+func qpdfPageArgs(path string, pages []int, replace map[int]string) []string {
+	var args []string
+	for i := 0; i < len(pages); {
+		if r, ok := replace[pages[i]]; ok {
+			args = append(args, r, "1")
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 && replace[pages[j+1]] == "" {
+			j++
+		}
+		args = append(args, path, fmt.Sprintf("%d-%d", pages[i], pages[j]))
+		i = j + 1
+	}
+	return args
+}
+
+func OCRHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[OCRHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	var req OCRRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.DPI == 0 {
+		req.DPI = defaultOCRDPI
+	}
+	if req.DPI < minOCRDPI || req.DPI > maxOCRDPI {
+		jsonError(w, fmt.Sprintf("'dpi' must be between %d and %d", minOCRDPI, maxOCRDPI), http.StatusBadRequest)
+		return
+	}
+	if len(req.Languages) == 0 {
+		req.Languages = []string{"eng"}
+	}
+
+	installed, err := utils.TesseractLanguages(r.Context())
+	if err != nil {
+		fmt.Println("[OCRHandler] ❌ Tesseract unavailable:", err)
+		jsonError(w, "OCR engine is not available", http.StatusServiceUnavailable)
+		return
+	}
+	for _, lang := range req.Languages {
+		if !slices.Contains(installed, lang) {
+			jsonError(w, fmt.Sprintf("Language %q is not installed. Available: %s", lang, strings.Join(installed, ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "ocr-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	pages, err := req.Pages.PagesOrAll(ctx.PageCount)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hasText := map[int]bool{}
+	if req.SkipText {
+		layout, err := utils.ExtractTextLayout(r.Context(), inputPath)
+		if err != nil {
+			fmt.Println("[OCRHandler] ❌ Text extraction failed:", err)
+			jsonError(w, "Failed to read PDF text: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		for _, page := range layout {
+			for _, line := range page.Lines {
+				if len(line.Words) > 0 {
+					hasText[page.Number] = true
+					break
+				}
+			}
+		}
+	}
+
+	workDir, err := os.MkdirTemp("", "ocr-")
+	if err != nil {
+		jsonError(w, "Failed to create temp dir", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	reports := make([]OCRPageReport, 0, len(pages))
+	rebuilt := map[int]string{}
+	var textLayers []string
+	var textPages []string
+	totalWords := 0
+	var confSum float64
+
+	for _, p := range pages {
+		if hasText[p] {
+			reports = append(reports, OCRPageReport{Page: p, Skipped: true})
+			continue
+		}
+
+		prefix := filepath.Join(workDir, "page-"+strconv.Itoa(p))
+		pngPath, err := utils.RenderPage(r.Context(), inputPath, p, req.DPI, prefix)
+		if err != nil {
+			fmt.Println("[OCRHandler] ❌ Rendering failed:", err)
+			jsonError(w, fmt.Sprintf("Failed to render page %d: %v", p, err), http.StatusInternalServerError)
+			return
+		}
+
+		rep := OCRPageReport{Page: p}
+		if req.Deskew {
+			box, rot, err := pageFrame(ctx, p)
+			if err != nil {
+				jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			width, height := box.Width(), box.Height()
+			if rot == 90 || rot == 270 {
+				width, height = height, width
+			}
+			angle, imagePDF, err := deskewPage(pngPath, width, height)
+			if err != nil {
+				fmt.Println("[OCRHandler] ❌ Deskew failed:", err)
+				jsonError(w, fmt.Sprintf("Failed to deskew page %d: %v", p, err), http.StatusInternalServerError)
+				return
+			}
+			if imagePDF != "" {
+				rebuilt[p] = imagePDF
+				rep.SkewAngle = math.Round(angle*100) / 100
+			}
+		}
+
+		res, err := utils.OCRImage(r.Context(), pngPath, prefix, req.Languages, req.DPI)
+		if err != nil {
+			fmt.Println("[OCRHandler] ❌ OCR failed:", err)
+			jsonError(w, fmt.Sprintf("Failed to recognise page %d: %v", p, err), http.StatusInternalServerError)
+			return
+		}
+		os.Remove(pngPath)
+
+		rep.Words = res.Words
+		rep.Confidence = math.Round(res.Confidence*10) / 10
+		reports = append(reports, rep)
+		textLayers = append(textLayers, res.PDFPath)
+		textPages = append(textPages, strconv.Itoa(p))
+		totalWords += res.Words
+		confSum += res.Confidence * float64(res.Words)
+	}
+
+	outputPath := inputPath
+	if len(rebuilt) > 0 {
+		stagePath := filepath.Join(workDir, "deskewed.pdf")
+		args := append([]string{inputPath, "--pages"}, qpdfPageArgs(inputPath, pageselect.Range(1, ctx.PageCount), rebuilt)...)
+		if _, err := utils.RunQPDF(r.Context(), append(args, "--", stagePath)...); err != nil {
+			fmt.Println("[OCRHandler] ❌ Assembly failed:", err)
+			jsonError(w, "Failed to assemble deskewed PDF: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		outputPath = stagePath
+	}
+
+	if len(textLayers) > 0 {
+		// Gather the text-only pages into one file and lay page i of it over
+		// the i-th recognised page.
+		textPath := filepath.Join(workDir, "text.pdf")
+		args := []string{"--empty", "--pages"}
+		for _, layer := range textLayers {
+			args = append(args, layer, "1")
+		}
+		if _, err := utils.RunQPDF(r.Context(), append(args, "--", textPath)...); err != nil {
+			fmt.Println("[OCRHandler] ❌ Assembly failed:", err)
+			jsonError(w, "Failed to assemble text layer: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ocrPath := filepath.Join(workDir, "ocr.pdf")
+		_, err := utils.RunQPDF(r.Context(), outputPath,
+			"--overlay", textPath, "--to="+strings.Join(textPages, ","), "--from=1-z", "--",
+			ocrPath)
+		if err != nil {
+			fmt.Println("[OCRHandler] ❌ Overlay failed:", err)
+			jsonError(w, "Failed to add text layer: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		outputPath = ocrPath
+	}
+
+	confidence := 0.0
+	if totalWords > 0 {
+		confidence = math.Round(confSum/float64(totalWords)*10) / 10
+	}
+	fmt.Printf("[OCRHandler] ✅ Recognised %d word(s) on %d page(s)\n", totalWords, len(textLayers))
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("ocr/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        url,
+		"confidence": confidence,
+		"pages":      reports,
+	})
+
+	fmt.Println("[OCRHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Lucifer7355/PDF/pageselect"
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// OverlayRequest places pages of the uploaded 'template' PDF over or under
+// pages of the base 'file'. An optional 'firstTemplate' upload supplies the
+// page used on the first base page, e.g. a full letterhead followed by a
+// plain continuation template.
+type OverlayRequest struct {
+	Pages         pageselect.Selection `json:"pages"`         // base pages to stamp, default all
+	Layer         string               `json:"layer"`         // "over" (default) or "under"
+	Mapping       string               `json:"mapping"`       // "fixed" (default), "match" or "cycle"
+	TemplatePage  int                  `json:"templatePage"`  // fixed: template page to use, default 1
+	FirstPage     int                  `json:"firstPage"`     // template page for base page 1; 0 keeps the mapping
+	Align         string               `json:"align"`         // "c" (default), "tl", "tc", "tr", "l", "r", "bl", "bc", "br"
+	Scale         float64              `json:"scale"`         // default 1
+	RelativeScale bool                 `json:"relativeScale"` // scale relative to the base page instead of the template's own size
+	OffsetX       float64              `json:"offsetX"`       // in points
+	OffsetY       float64              `json:"offsetY"`
+	Opacity       float64              `json:"opacity"` // 0 < opacity <= 1, default 1
+}
+
+// templateRef identifies one page of one of the template files.
+type templateRef struct {
+	first bool // taken from the 'firstTemplate' upload
+	page  int
+}
+
+// overlayAssignments decides which template page goes onto each of the
+// selected base pages. Pages that get no template are left out.
+func overlayAssignments(req OverlayRequest, pages []int, templatePages, firstTemplatePages int) (map[templateRef][]int, error) {
+	out := map[templateRef][]int{}
+	for _, p := range pages {
+		var ref templateRef
+		switch {
+		case p == 1 && firstTemplatePages > 0:
+			ref = templateRef{first: true, page: max(req.FirstPage, 1)}
+			if ref.page > firstTemplatePages {
+				return nil, fmt.Errorf("'firstPage' %d is out of range: the first-page template has %d page(s)", ref.page, firstTemplatePages)
+			}
+		case p == 1 && req.FirstPage > 0:
+			ref.page = req.FirstPage
+		case req.Mapping == "match":
+			if p > templatePages {
+				continue
+			}
+			ref.page = p
+		case req.Mapping == "cycle":
+			ref.page = (p-1)%templatePages + 1
+		default:
+			ref.page = req.TemplatePage
+		}
+		if !ref.first && ref.page > templatePages {
+			return nil, fmt.Errorf("template page %d is out of range: the template has %d page(s)", ref.page, templatePages)
+		}
+		out[ref] = append(out[ref], p)
+	}
+	return out, nil
+}
+
+func overlayDescription(req OverlayRequest) string {
+	scale := strconv.FormatFloat(req.Scale, 'f', -1, 64)
+	if req.RelativeScale {
+		scale += " rel"
+	} else {
+		scale += " abs"
+	}
+	return fmt.Sprintf("position:%s, scalefactor:%s, offset:%s %s, opacity:%s, rotation:0",
+		req.Align,
+		scale,
+		strconv.FormatFloat(req.OffsetX, 'f', -1, 64),
+		strconv.FormatFloat(req.OffsetY, 'f', -1, 64),
+		strconv.FormatFloat(req.Opacity, 'f', -1, 64),
+	)
+}
+
+// applyOverlay stamps the assigned template pages onto ctx. Base pages
+// sharing a template page are stamped together so each template page is
+// copied into the document only once.
+func applyOverlay(ctx *model.Context, assignments map[templateRef][]int, templatePath, firstTemplatePath string, req OverlayRequest) error {
+	refs := make([]templateRef, 0, len(assignments))
+	for ref := range assignments {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].first != refs[j].first {
+			return refs[i].first
+		}
+		return refs[i].page < refs[j].page
+	})
+
+	desc := overlayDescription(req)
+	for _, ref := range refs {
+		path := templatePath
+		if ref.first {
+			path = firstTemplatePath
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		wm, err := api.PDFWatermarkForReadSeeker(f, ref.page, desc, req.Layer == "over", false, types.POINTS)
+		if err == nil {
+			selected := types.IntSet{}
+			for _, p := range assignments[ref] {
+				selected[p] = true
+			}
+			err = api.WatermarkContext(ctx, selected, wm)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func OverlayHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[OverlayHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	var req OverlayRequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Layer == "" {
+		req.Layer = "over"
+	}
+	if req.Layer != "over" && req.Layer != "under" {
+		jsonError(w, "Invalid layer. Use 'over' or 'under'", http.StatusBadRequest)
+		return
+	}
+	if req.Mapping == "" {
+		req.Mapping = "fixed"
+	}
+	if req.Mapping != "fixed" && req.Mapping != "match" && req.Mapping != "cycle" {
+		jsonError(w, "Invalid mapping. Use 'fixed', 'match' or 'cycle'", http.StatusBadRequest)
+		return
+	}
+	if req.TemplatePage == 0 {
+		req.TemplatePage = 1
+	}
+	if req.TemplatePage < 0 || req.FirstPage < 0 {
+		jsonError(w, "Template page numbers must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Align == "" {
+		req.Align = "c"
+	}
+	if a, err := types.ParsePositionAnchor(req.Align); err != nil || a == types.Full {
+		jsonError(w, fmt.Sprintf("Invalid align %q", req.Align), http.StatusBadRequest)
+		return
+	}
+	if req.Scale == 0 {
+		req.Scale = 1
+	}
+	if req.Scale < 0 {
+		jsonError(w, "'scale' must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Opacity == 0 {
+		req.Opacity = 1
+	}
+	if req.Opacity < 0 || req.Opacity > 1 {
+		jsonError(w, "'opacity' must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "overlay-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	templatePath, ok := saveUploadedField(w, r, "template", "overlay-template-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(templatePath)
+
+	var firstTemplatePath string
+	if len(r.MultipartForm.File["firstTemplate"]) > 0 {
+		if firstTemplatePath, ok = saveUploadedField(w, r, "firstTemplate", "overlay-first-*.pdf"); !ok {
+			return
+		}
+		defer os.Remove(firstTemplatePath)
+	}
+
+	templatePages, err := api.PageCountFile(templatePath)
+	if err != nil {
+		jsonError(w, "Failed to read template PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	var firstTemplatePages int
+	if firstTemplatePath != "" {
+		if firstTemplatePages, err = api.PageCountFile(firstTemplatePath); err != nil {
+			jsonError(w, "Failed to read first-page template PDF: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	pages, err := req.Pages.PagesOrAll(ctx.PageCount)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	assignments, err := overlayAssignments(req, pages, templatePages, firstTemplatePages)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := applyOverlay(ctx, assignments, templatePath, firstTemplatePath, req); err != nil {
+		fmt.Println("[OverlayHandler] ❌ Overlay failed:", err)
+		jsonError(w, "Failed to apply template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	outputPath := inputPath + "-overlay.pdf"
+	defer os.Remove(outputPath)
+
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		jsonError(w, "Failed to write PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	stamped := 0
+	for _, ps := range assignments {
+		stamped += len(ps)
+	}
+	fmt.Printf("[OverlayHandler] ✅ Applied template to %d page(s)\n", stamped)
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("overlay/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":   url,
+		"pages": stamped,
+	})
+
+	fmt.Println("[OverlayHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+type PDFARequest struct {
+	Level string `json:"level"` // "1b", "2b" or "3b"
+}
+
+type PDFAViolation struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Count       int    `json:"count"`
+}
+
+type PDFAReport struct {
+	ClaimedLevel string          `json:"claimedLevel"` // from the XMP pdfaid entries, empty if none
+	CheckedLevel string          `json:"checkedLevel"`
+	Compliant    bool            `json:"compliant"`
+	Violations   []PDFAViolation `json:"violations"`
+}
+
+// defaultICCProfile is the sRGB profile shipped with Debian's ghostscript.
+const defaultICCProfile = "/usr/share/color/icc/ghostscript/srgb.icc"
+
+var (
+	xmpPartPattern        = regexp.MustCompile(`pdfaid:part(?:>|\s*=\s*["'])\s*(\d)`)
+	xmpConformancePattern = regexp.MustCompile(`pdfaid:conformance(?:>|\s*=\s*["'])\s*([ABUabu])`)
+)
+
+func normalizePDFALevel(level string) (string, error) {
+	l := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(level)), "pdf/a-")
+	switch l {
+	case "1b", "2b", "3b":
+		return l, nil
+	}
+	return "", fmt.Errorf("Invalid PDF/A level '%s'. Use '1b', '2b' or '3b'", level)
+}
+
+// pdfaChecker collects violations while walking the document objects.
+type pdfaChecker struct {
+	level      string
+	xRefTable  *model.XRefTable
+	violations map[string]*PDFAViolation
+}
+
+func (c *pdfaChecker) add(code, description string) {
+	if v, ok := c.violations[code]; ok {
+		v.Count++
+		return
+	}
+	c.violations[code] = &PDFAViolation{Code: code, Description: description, Count: 1}
+}
+
+// hasPDFAOutputIntent reports whether the catalog has a GTS_PDFA1 output
+// intent with an embedded ICC profile.
+func hasPDFAOutputIntent(xRefTable *model.XRefTable, root types.Dict) bool {
+	intents, err := xRefTable.DereferenceArray(root["OutputIntents"])
+	if err != nil {
+		return false
+	}
+	for _, o := range intents {
+		d, err := xRefTable.DereferenceDict(o)
+		if err != nil || d == nil {
+			continue
+		}
+		if s := d.NameEntry("S"); s != nil && *s == "GTS_PDFA1" && d["DestOutputProfile"] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *pdfaChecker) checkCatalog() string {
+	claimed := ""
+	root := c.xRefTable.RootDict
+
+	sd, _, err := c.xRefTable.DereferenceStreamDict(root["Metadata"])
+	if err != nil || sd == nil {
+		c.add("xmp-missing", "Document catalog has no XMP metadata stream")
+	} else if err := sd.Decode(); err != nil {
+		c.add("xmp-unreadable", "XMP metadata stream cannot be decoded")
+	} else {
+		part := xmpPartPattern.FindSubmatch(sd.Content)
+		conf := xmpConformancePattern.FindSubmatch(sd.Content)
+		if part == nil || conf == nil {
+			c.add("xmp-no-pdfaid", "XMP metadata does not declare a PDF/A part and conformance")
+		} else {
+			claimed = string(part[1]) + strings.ToLower(string(conf[1]))
+		}
+	}
+
+	if !hasPDFAOutputIntent(c.xRefTable, root) {
+		c.add("output-intent-missing", "No GTS_PDFA1 output intent with an embedded ICC color profile")
+	}
+	return claimed
+}
+
+func (c *pdfaChecker) checkNames() {
+	root := c.xRefTable.RootDict
+	if names, err := c.xRefTable.DereferenceDict(root["Names"]); err == nil && names != nil {
+		if names["JavaScript"] != nil {
+			c.add("javascript", "Document contains JavaScript")
+		}
+		if names["EmbeddedFiles"] != nil && c.level != "3b" {
+			c.add("embedded-files", "Embedded files are only permitted in PDF/A-3")
+		}
+	}
+}
+
+func (c *pdfaChecker) checkFont(d types.Dict) {
+	subtype := d.Subtype()
+	if subtype == nil || *subtype == "Type0" || *subtype == "Type3" {
+		// Type0 fonts are checked through their descendant CIDFonts.
+		return
+	}
+
+	name := "unnamed"
+	if bf := d.NameEntry("BaseFont"); bf != nil {
+		name = *bf
+	}
+
+	fd, err := c.xRefTable.DereferenceDict(d["FontDescriptor"])
+	if err != nil || fd == nil {
+		c.add("font-not-embedded:"+name, fmt.Sprintf("Font %s is not embedded", name))
+		return
+	}
+	if fd["FontFile"] == nil && fd["FontFile2"] == nil && fd["FontFile3"] == nil {
+		c.add("font-not-embedded:"+name, fmt.Sprintf("Font %s is not embedded", name))
+	}
+}
+
+func (c *pdfaChecker) checkFilters(d types.Dict) {
+	filters := []types.Object{d["Filter"]}
+	if arr, ok := d["Filter"].(types.Array); ok {
+		filters = arr
+	}
+	for _, f := range filters {
+		if n, ok := f.(types.Name); ok && (n == "LZWDecode" || n == "LZW") {
+			c.add("lzw", "LZW compression is not permitted")
+		}
+	}
+}
+
+func (c *pdfaChecker) checkTransparency(d types.Dict) {
+	if c.level != "1b" {
+		// PDF/A-2 and later allow transparency.
+		return
+	}
+	if sm, ok := d["SMask"]; ok {
+		if n, isName := sm.(types.Name); !isName || n != "None" {
+			c.add("transparency-smask", "Soft masks (transparency) are not permitted in PDF/A-1")
+		}
+	}
+	for _, key := range []string{"CA", "ca"} {
+		if o, ok := d[key]; ok {
+			if v, err := c.xRefTable.DereferenceNumber(o); err == nil && v < 1 {
+				c.add("transparency-alpha", "Constant alpha below 1.0 is not permitted in PDF/A-1")
+			}
+		}
+	}
+	if bm := d.NameEntry("BM"); bm != nil && *bm != "Normal" && *bm != "Compatible" {
+		c.add("transparency-blend", "Blend modes other than Normal are not permitted in PDF/A-1")
+	}
+	if g := d.DictEntry("Group"); g != nil {
+		if s := g.NameEntry("S"); s != nil && *s == "Transparency" {
+			c.add("transparency-group", "Transparency groups are not permitted in PDF/A-1")
+		}
+	}
+}
+
+func (c *pdfaChecker) checkObject(o types.Object) {
+	var d types.Dict
+	switch obj := o.(type) {
+	case types.Dict:
+		d = obj
+	case types.StreamDict:
+		d = obj.Dict
+		c.checkFilters(d)
+	default:
+		return
+	}
+
+	if t := d.Type(); t != nil && *t == "Font" {
+		c.checkFont(d)
+	}
+	if s := d.NameEntry("S"); s != nil && *s == "JavaScript" {
+		c.add("javascript", "Document contains JavaScript")
+	}
+	c.checkTransparency(d)
+}
+
+// validatePDFA checks the file at path against the given PDF/A level. An
+// empty level means the level claimed in the XMP metadata, or 1b if none.
+func validatePDFA(path, level string) (*PDFAReport, error) {
+	report := &PDFAReport{}
+
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "password") || strings.Contains(strings.ToLower(err.Error()), "encrypt") {
+			report.CheckedLevel = level
+			report.Violations = []PDFAViolation{{Code: "encrypted", Description: "Document is encrypted", Count: 1}}
+			return report, nil
+		}
+		return nil, err
+	}
+
+	c := &pdfaChecker{level: level, xRefTable: ctx.XRefTable, violations: map[string]*PDFAViolation{}}
+	if ctx.XRefTable.Encrypt != nil {
+		c.add("encrypted", "Document is encrypted")
+	}
+
+	report.ClaimedLevel = c.checkCatalog()
+	if c.level == "" {
+		c.level = report.ClaimedLevel
+		if _, err := normalizePDFALevel(c.level); err != nil {
+			c.level = "1b"
+		}
+	}
+	report.CheckedLevel = c.level
+	c.checkNames()
+
+	objNrs := make([]int, 0, len(ctx.XRefTable.Table))
+	for objNr := range ctx.XRefTable.Table {
+		objNrs = append(objNrs, objNr)
+	}
+	sort.Ints(objNrs)
+	for _, objNr := range objNrs {
+		entry := ctx.XRefTable.Table[objNr]
+		if entry == nil || entry.Free || entry.Object == nil {
+			continue
+		}
+		c.checkObject(entry.Object)
+	}
+
+	if report.ClaimedLevel != "" && report.ClaimedLevel != c.level {
+		c.add("level-mismatch", fmt.Sprintf("XMP declares PDF/A-%s but PDF/A-%s was requested", report.ClaimedLevel, c.level))
+	}
+
+	codes := make([]string, 0, len(c.violations))
+	for code := range c.violations {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		report.Violations = append(report.Violations, *c.violations[code])
+	}
+	report.Compliant = len(report.Violations) == 0
+	return report, nil
+}
+
+// pdfaDefinition returns the PostScript prologue ghostscript needs to embed
+// the output intent and ICC profile for PDF/A output.
+func pdfaDefinition(iccPath string) string {
+	return fmt.Sprintf(`%%!
+[/_objdef {icc_PDFA} /type /stream /OBJ pdfmark
+[{icc_PDFA} << /N 3 >> /PUT pdfmark
+[{icc_PDFA} (%s) (r) file /PUT pdfmark
+[/_objdef {OutputIntent_PDFA} /type /dict /OBJ pdfmark
+[{OutputIntent_PDFA} <<
+  /Type /OutputIntent
+  /S /GTS_PDFA1
+  /DestOutputProfile {icc_PDFA}
+  /OutputConditionIdentifier (sRGB IEC61966-2.1)
+  /Info (sRGB IEC61966-2.1)
+>> /PUT pdfmark
+[{Catalog} << /OutputIntents [ {OutputIntent_PDFA} ] >> /PUT pdfmark
+`, iccPath)
+}
+
+// savePDFAUpload parses the request and stores the uploaded PDF, returning
+// the requested level and the temp file path.
+func savePDFAUpload(w http.ResponseWriter, r *http.Request, tag string) (string, string, bool) {
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	var req PDFARequest
+	if meta := r.FormValue("meta"); meta != "" {
+		if err := json.Unmarshal([]byte(meta), &req); err != nil {
+			jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+			return "", "", false
+		}
+	}
+	level := ""
+	if req.Level != "" {
+		if level, err = normalizePDFALevel(req.Level); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return "", "", false
+		}
+	}
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		jsonError(w, "Missing 'file' field", http.StatusBadRequest)
+		return "", "", false
+	}
+	file, err := files[0].Open()
+	if err != nil {
+		jsonError(w, "Unable to read uploaded file", http.StatusBadRequest)
+		return "", "", false
+	}
+	defer file.Close()
+
+	inputTmp, err := os.CreateTemp("", tag+"-in-*.pdf")
+	if err != nil {
+		jsonError(w, "Failed to create temp file", http.StatusInternalServerError)
+		return "", "", false
+	}
+	defer inputTmp.Close()
+
+	if _, err := io.Copy(inputTmp, file); err != nil {
+		os.Remove(inputTmp.Name())
+		jsonError(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return "", "", false
+	}
+	return level, inputTmp.Name(), true
+}
+
+func PDFAValidateHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[PDFAValidateHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	level, inputPath, ok := savePDFAUpload(w, r, "pdfa-validate")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	report, err := validatePDFA(inputPath, level)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Printf("[PDFAValidateHandler] ✅ PDF/A-%s check: %d violation(s)\n", report.CheckedLevel, len(report.Violations))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+
+	fmt.Println("[PDFAValidateHandler] ✅ Done in", time.Since(start))
+}
+
+func PDFAConvertHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[PDFAConvertHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	level, inputPath, ok := savePDFAUpload(w, r, "pdfa-convert")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+	if level == "" {
+		level = "2b"
+	}
+
+	workDir, err := os.MkdirTemp("", "pdfa-")
+	if err != nil {
+		jsonError(w, "Failed to create working directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	iccPath := os.Getenv("PDFA_ICC_PROFILE")
+	if iccPath == "" {
+		iccPath = defaultICCProfile
+	}
+	defPath := filepath.Join(workDir, "PDFA_def.ps")
+	if err := os.WriteFile(defPath, []byte(pdfaDefinition(iccPath)), 0644); err != nil {
+		jsonError(w, "Failed to prepare PDF/A definition", http.StatusInternalServerError)
+		return
+	}
+
+	compatibility := "1.7"
+	if level == "1b" {
+		compatibility = "1.4"
+	}
+	outputPath := filepath.Join(workDir, "output.pdf")
+
+	out, err := utils.RunGhostscript(r.Context(),
+		"--permit-file-read="+iccPath,
+		"-sDEVICE=pdfwrite",
+		"-dPDFA="+level[:1],
+		"-dPDFACompatibilityPolicy=1",
+		"-dCompatibilityLevel="+compatibility,
+		"-sColorConversionStrategy=RGB",
+		"-sProcessColorModel=DeviceRGB",
+		"-dEmbedAllFonts=true",
+		"-sOutputFile="+outputPath,
+		defPath,
+		inputPath,
+	)
+	if err != nil {
+		fmt.Println("[PDFAConvertHandler] ❌ ghostscript failed:", err)
+		jsonError(w, "PDF/A conversion failed: "+strings.TrimSpace(string(out)), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := validatePDFA(outputPath, level)
+	if err != nil {
+		jsonError(w, "Failed to validate converted PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("[PDFAConvertHandler] ✅ Converted to PDF/A-%s, %d remaining violation(s)\n", level, len(report.Violations))
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open converted PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("pdfa/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":    url,
+		"report": report,
+	})
+
+	fmt.Println("[PDFAConvertHandler] ✅ Done in", time.Since(start))
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/pageselect"
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// PageResize fits pages onto a paper size. "fit" scales the content up or
+// down to fill the sheet; "pad" never enlarges it and only adds space.
+type PageResize struct {
+	PaperSize string `json:"paperSize"` // e.g. "A4", "A4L", "Letter"
+	Mode      string `json:"mode"`      // "fit" (default) or "pad"
+}
+
+// PageMargins are added around the page as displayed, in points.
+type PageMargins struct {
+	Top    float64 `json:"top"`
+	Right  float64 `json:"right"`
+	Bottom float64 `json:"bottom"`
+	Left   float64 `json:"left"`
+}
+
+// PageGeometryRequest describes the changes made to the selected pages.
+// They are applied in the order crop, resize, margins, boxes. Boxes and
+// crop rectangles are [llx lly urx ury] in the page's own coordinates.
+type PageGeometryRequest struct {
+	Pages           pageselect.Selection `json:"pages"`           // default all
+	Crop            []float64            `json:"crop"`            // explicit crop box
+	AutoCrop        bool                 `json:"autoCrop"`        // crop to the painted area
+	AutoCropPadding float64              `json:"autoCropPadding"` // space kept around the painted area, in points
+	Resize          *PageResize          `json:"resize"`
+	Margins         *PageMargins         `json:"margins"`
+	Boxes           map[string][]float64 `json:"boxes"` // "media", "crop", "trim", "bleed", "art"; an empty list removes the box
+}
+
+var pageBoxKeys = map[string]string{
+	"media": "MediaBox",
+	"crop":  "CropBox",
+	"trim":  "TrimBox",
+	"bleed": "BleedBox",
+	"art":   "ArtBox",
+}
+
+func rectFromSlice(v []float64) (*types.Rectangle, error) {
+	if len(v) != 4 {
+		return nil, fmt.Errorf("a box needs four numbers [llx lly urx ury], got %d", len(v))
+	}
+	if v[0] >= v[2] || v[1] >= v[3] {
+		return nil, fmt.Errorf("box %v is empty; the lower-left corner must come first", v)
+	}
+	return types.NewRectangle(v[0], v[1], v[2], v[3]), nil
+}
+
+func normalizeRotation(rot int) int {
+	return ((rot % 360) + 360) % 360
+}
+
+// autoCropBox converts a bounding box reported by ghostscript, which is
+// measured on the page as displayed, back into the page's own coordinates.
+func autoCropBox(bbox [4]float64, media *types.Rectangle, rot int, padding float64) *types.Rectangle {
+	w, h := media.Width(), media.Height()
+	toUser := func(x, y float64) (float64, float64) {
+		switch rot {
+		case 90:
+			return w - y, x
+		case 180:
+			return w - x, h - y
+		case 270:
+			return y, h - x
+		}
+		return x, y
+	}
+	x1, y1 := toUser(bbox[0], bbox[1])
+	x2, y2 := toUser(bbox[2], bbox[3])
+
+	r := types.NewRectangle(
+		media.LL.X+math.Min(x1, x2)-padding,
+		media.LL.Y+math.Min(y1, y2)-padding,
+		media.LL.X+math.Max(x1, x2)+padding,
+		media.LL.Y+math.Max(y1, y2)+padding,
+	)
+	r.LL.X = math.Max(r.LL.X, media.LL.X)
+	r.LL.Y = math.Max(r.LL.Y, media.LL.Y)
+	r.UR.X = math.Min(r.UR.X, media.UR.X)
+	r.UR.Y = math.Min(r.UR.Y, media.UR.Y)
+	return r
+}
+
+// resizePage scales the visible area of a page onto a sheet of the given
+// size, centred, by wrapping its content in a transformation. pdfcpu's own
+// resize scales about the origin and misplaces pages whose crop box does
+// not start at 0,0.
+func resizePage(ctx *model.Context, pageDict types.Dict, visible *types.Rectangle, rot int, dim *types.Dim, mode string) error {
+	w, h := dim.Width, dim.Height
+	if rot == 90 || rot == 270 {
+		w, h = h, w
+	}
+
+	s := math.Min(w/visible.Width(), h/visible.Height())
+	if mode == "pad" {
+		s = math.Min(s, 1)
+	}
+	tx := (w-s*visible.Width())/2 - s*visible.LL.X
+	ty := (h-s*visible.Height())/2 - s*visible.LL.Y
+	transform := func(r *types.Rectangle) *types.Rectangle {
+		return types.NewRectangle(s*r.LL.X+tx, s*r.LL.Y+ty, s*r.UR.X+tx, s*r.UR.Y+ty)
+	}
+
+	// Clip to the old visible area, which is no longer kept as a crop box,
+	// so that content outside it stays hidden in the padding.
+	pre, err := newContentStream(ctx, fmt.Sprintf("q %.5f 0 0 %.5f %.5f %.5f cm\n%.5f %.5f %.5f %.5f re W n\n",
+		s, s, tx, ty, visible.LL.X, visible.LL.Y, visible.Width(), visible.Height()))
+	if err != nil {
+		return err
+	}
+	post, err := newContentStream(ctx, "\nQ\n")
+	if err != nil {
+		return err
+	}
+	contents := types.Array{*pre}
+	if obj, ok := pageDict.Find("Contents"); ok {
+		o, err := ctx.Dereference(obj)
+		if err != nil {
+			return err
+		}
+		// Contents is either a single stream or an array of streams.
+		if arr, isArray := o.(types.Array); isArray {
+			contents = append(contents, arr...)
+		} else if o != nil {
+			contents = append(contents, obj)
+		}
+	}
+	pageDict["Contents"] = append(contents, *post)
+
+	for _, key := range []string{"TrimBox", "BleedBox", "ArtBox"} {
+		obj, ok := pageDict.Find(key)
+		if !ok {
+			continue
+		}
+		arr, err := ctx.DereferenceArray(obj)
+		if err != nil || len(arr) != 4 {
+			delete(pageDict, key)
+			continue
+		}
+		r, err := ctx.RectForArray(arr)
+		if err != nil {
+			delete(pageDict, key)
+			continue
+		}
+		pageDict[key] = transform(r).Array()
+	}
+
+	if obj, ok := pageDict.Find("Annots"); ok {
+		annots, err := ctx.DereferenceArray(obj)
+		if err != nil {
+			return err
+		}
+		for _, a := range annots {
+			d, err := ctx.DereferenceDict(a)
+			if err != nil || d == nil {
+				continue
+			}
+			arr, err := ctx.DereferenceArray(d["Rect"])
+			if err != nil || len(arr) != 4 {
+				continue
+			}
+			if r, err := ctx.RectForArray(arr); err == nil {
+				d["Rect"] = transform(r).Array()
+			}
+		}
+	}
+
+	pageDict["MediaBox"] = types.NewRectangle(0, 0, w, h).Array()
+	delete(pageDict, "CropBox")
+	return nil
+}
+
+func newContentStream(ctx *model.Context, content string) (*types.IndirectRef, error) {
+	sd, err := ctx.NewStreamDictForBuf([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	if err := sd.Encode(); err != nil {
+		return nil, err
+	}
+	return ctx.IndRefForNewObject(*sd)
+}
+
+// addMargins grows the media box, and the crop box when the page has one,
+// by the given margins. The sides refer to the page as displayed, so they
+// are mapped onto the unrotated page first.
+func addMargins(pageDict types.Dict, media, crop *types.Rectangle, rot int, m PageMargins) {
+	left, bottom, right, top := m.Left, m.Bottom, m.Right, m.Top
+	switch rot {
+	case 90:
+		left, bottom, right, top = m.Top, m.Left, m.Bottom, m.Right
+	case 180:
+		left, bottom, right, top = m.Right, m.Top, m.Left, m.Bottom
+	case 270:
+		left, bottom, right, top = m.Bottom, m.Right, m.Top, m.Left
+	}
+	grow := func(r *types.Rectangle) types.Array {
+		return types.NewRectangle(r.LL.X-left, r.LL.Y-bottom, r.UR.X+right, r.UR.Y+top).Array()
+	}
+	pageDict["MediaBox"] = grow(media)
+	if crop != nil {
+		pageDict["CropBox"] = grow(crop)
+	}
+}
+
+func PageGeometryHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[PageGeometryHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req PageGeometryRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+
+	var crop *types.Rectangle
+	if req.Crop != nil {
+		if req.AutoCrop {
+			jsonError(w, "Use either 'crop' or 'autoCrop', not both", http.StatusBadRequest)
+			return
+		}
+		if crop, err = rectFromSlice(req.Crop); err != nil {
+			jsonError(w, "Invalid crop: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.AutoCropPadding < 0 {
+		jsonError(w, "'autoCropPadding' must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	var paper *types.Dim
+	if req.Resize != nil {
+		if req.Resize.Mode == "" {
+			req.Resize.Mode = "fit"
+		}
+		if req.Resize.Mode != "fit" && req.Resize.Mode != "pad" {
+			jsonError(w, "Invalid resize mode. Use 'fit' or 'pad'", http.StatusBadRequest)
+			return
+		}
+		if paper, _, err = types.ParsePageFormat(req.Resize.PaperSize); err != nil {
+			jsonError(w, fmt.Sprintf("Unknown paper size %q", req.Resize.PaperSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if m := req.Margins; m != nil && (m.Top < 0 || m.Right < 0 || m.Bottom < 0 || m.Left < 0) {
+		jsonError(w, "Margins must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	boxes := map[string]*types.Rectangle{}
+	for name, v := range req.Boxes {
+		key, ok := pageBoxKeys[strings.ToLower(name)]
+		if !ok {
+			jsonError(w, fmt.Sprintf("Unknown box %q. Use 'media', 'crop', 'trim', 'bleed' or 'art'", name), http.StatusBadRequest)
+			return
+		}
+		if len(v) == 0 {
+			if key == "MediaBox" {
+				jsonError(w, "The media box cannot be removed", http.StatusBadRequest)
+				return
+			}
+			boxes[key] = nil
+			continue
+		}
+		rect, err := rectFromSlice(v)
+		if err != nil {
+			jsonError(w, fmt.Sprintf("Invalid %s box: %s", name, err.Error()), http.StatusBadRequest)
+			return
+		}
+		boxes[key] = rect
+	}
+
+	if crop == nil && !req.AutoCrop && paper == nil && req.Margins == nil && len(boxes) == 0 {
+		jsonError(w, "Nothing to do: give at least one of 'crop', 'autoCrop', 'resize', 'margins' or 'boxes'", http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "geometry-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	ctx, err := api.ReadContextFile(inputPath)
+	if err != nil {
+		jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	pages, err := req.Pages.PagesOrAll(ctx.PageCount)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var bboxes [][4]float64
+	if req.AutoCrop {
+		bboxes, err = utils.BoundingBoxes(r.Context(), inputPath)
+		if err != nil {
+			fmt.Println("[PageGeometryHandler] ❌ Bounding box detection failed:", err)
+			jsonError(w, "Failed to detect page content: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(bboxes) != ctx.PageCount {
+			jsonError(w, fmt.Sprintf("Content detection returned %d pages, expected %d", len(bboxes), ctx.PageCount), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for _, pageNr := range pages {
+		pageDict, _, inh, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil || inh == nil || inh.MediaBox == nil {
+			jsonError(w, fmt.Sprintf("Cannot read page %d", pageNr), http.StatusUnprocessableEntity)
+			return
+		}
+		media, pageCrop := inh.MediaBox, inh.CropBox
+		rot := normalizeRotation(inh.Rotate)
+
+		switch {
+		case crop != nil:
+			pageCrop = crop
+		case req.AutoCrop:
+			// Pages with nothing painted on them keep their size.
+			if b := bboxes[pageNr-1]; b[2] > b[0] && b[3] > b[1] {
+				pageCrop = autoCropBox(b, media, rot, req.AutoCropPadding)
+			}
+		}
+		if pageCrop != nil {
+			pageDict["CropBox"] = pageCrop.Array()
+		}
+
+		if paper != nil {
+			visible := media
+			if pageCrop != nil {
+				visible = pageCrop
+			}
+			if err := resizePage(ctx, pageDict, visible, rot, paper, req.Resize.Mode); err != nil {
+				jsonError(w, fmt.Sprintf("Failed to resize page %d: %s", pageNr, err.Error()), http.StatusInternalServerError)
+				return
+			}
+			media = types.NewRectangle(0, 0, paper.Width, paper.Height)
+			if rot == 90 || rot == 270 {
+				media = types.NewRectangle(0, 0, paper.Height, paper.Width)
+			}
+			pageCrop = nil
+		}
+
+		if req.Margins != nil {
+			addMargins(pageDict, media, pageCrop, rot, *req.Margins)
+		}
+
+		for key, rect := range boxes {
+			if rect == nil {
+				delete(pageDict, key)
+				continue
+			}
+			pageDict[key] = rect.Array()
+		}
+	}
+
+	outputPath := inputPath + "-geometry.pdf"
+	defer os.Remove(outputPath)
+
+	if err := api.WriteContextFile(ctx, outputPath); err != nil {
+		fmt.Println("[PageGeometryHandler] ❌ Failed to write PDF:", err)
+		jsonError(w, "Failed to write PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("[PageGeometryHandler] ✅ Updated %d page(s)\n", len(pages))
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("geometry/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":   url,
+		"pages": len(pages),
+	})
+
+	fmt.Println("[PageGeometryHandler] ✅ Done in", time.Since(start))
+}
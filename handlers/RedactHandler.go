@@ -0,0 +1,624 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// RedactRegion is a rectangle in points on the displayed page, measured
+// from the bottom-left corner like PDF user space.
+type RedactRegion struct {
+	Page   int     `json:"page"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+type RedactRequest struct {
+	Regions  []RedactRegion `json:"regions"`
+	Terms    []string       `json:"terms"`    // literal text, matched case-insensitively
+	Patterns []string       `json:"patterns"` // regular expressions
+	Presets  []string       `json:"presets"`  // see redactPresets
+	DPI      int            `json:"dpi"`      // resolution of redacted pages, default 150, at most 600
+}
+
+type RedactionMatch struct {
+	Rule string `json:"rule"`
+	Text string `json:"text"`
+}
+
+type RedactionPageReport struct {
+	Page               int              `json:"page"`
+	Boxes              int              `json:"boxes"`
+	WordsRemoved       int              `json:"wordsRemoved"`
+	AnnotationsRemoved int              `json:"annotationsRemoved,omitempty"`
+	Matches            []RedactionMatch `json:"matches,omitempty"`
+}
+
+var redactPresets = map[string]string{
+	"email":       `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ssn":         `\b\d{3}-\d{2}-\d{4}\b`,
+	"phone":       `\+?\(?\d{1,4}\)?[\s.-]?\(?\d{2,4}\)?[\s.-]?\d{3,4}[\s.-]?\d{3,4}\b`,
+	"credit-card": `\b(?:\d[ -]?){12,18}\d\b`,
+	"iban":        `\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`,
+}
+
+const (
+	defaultRedactDPI = 150
+	maxRedactDPI     = 600
+	redactPadding    = 1.0 // points added around matched words
+)
+
+type redactRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// redactBox is a rectangle in points from the top-left corner of the page,
+// the orientation used by poppler and by the rendered page image.
+type redactBox struct {
+	x0, y0, x1, y1 float64
+}
+
+func (b redactBox) intersects(o redactBox) bool {
+	return b.x0 < o.x1 && o.x0 < b.x1 && b.y0 < o.y1 && o.y0 < b.y1
+}
+
+func (req RedactRequest) rules() ([]redactRule, error) {
+	var rules []redactRule
+	for _, t := range req.Terms {
+		// Like search, a term matches across any run of whitespace, so it
+		// is found where it wraps onto the next line.
+		words := strings.Fields(t)
+		if len(words) == 0 {
+			continue
+		}
+		for i, w := range words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		rules = append(rules, redactRule{name: "term:" + t, re: regexp.MustCompile("(?i)" + strings.Join(words, `\s+`))})
+	}
+	for _, p := range req.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+		rules = append(rules, redactRule{name: "pattern:" + p, re: re})
+	}
+	for _, name := range req.Presets {
+		expr, ok := redactPresets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q", name)
+		}
+		rules = append(rules, redactRule{name: "preset:" + name, re: regexp.MustCompile(expr)})
+	}
+	return rules, nil
+}
+
+// findRedactions works out the boxes to black out on every page. Search
+// rules run against the words of a page joined by single spaces, as in
+// searchLayout, so that matches can span words and lines; every word
+// touched by a match is redacted in full.
+func findRedactions(layout []utils.TextPage, regions []RedactRegion, rules []redactRule) (map[int][]redactBox, map[int]*RedactionPageReport) {
+	boxes := map[int][]redactBox{}
+	reports := map[int]*RedactionPageReport{}
+	report := func(page int) *RedactionPageReport {
+		if reports[page] == nil {
+			reports[page] = &RedactionPageReport{Page: page}
+		}
+		return reports[page]
+	}
+
+	for _, reg := range regions {
+		if reg.Page < 1 || reg.Page > len(layout) {
+			continue
+		}
+		h := layout[reg.Page-1].Height
+		boxes[reg.Page] = append(boxes[reg.Page], redactBox{reg.X, h - reg.Y - reg.Height, reg.X + reg.Width, h - reg.Y})
+	}
+
+	for _, page := range layout {
+		var words []utils.TextWord
+		var starts []int
+		var b strings.Builder
+		for _, line := range page.Lines {
+			for _, w := range line.Words {
+				if b.Len() > 0 {
+					b.WriteByte(' ')
+				}
+				starts = append(starts, b.Len())
+				b.WriteString(w.Text)
+				words = append(words, w)
+			}
+		}
+		text := b.String()
+
+		for _, rule := range rules {
+			for _, m := range rule.re.FindAllStringIndex(text, -1) {
+				if m[0] == m[1] {
+					continue
+				}
+				for i, w := range words {
+					if starts[i] < m[1] && m[0] < starts[i]+len(w.Text) {
+						boxes[page.Number] = append(boxes[page.Number], redactBox{
+							w.XMin - redactPadding, w.YMin - redactPadding,
+							w.XMax + redactPadding, w.YMax + redactPadding,
+						})
+					}
+				}
+				r := report(page.Number)
+				r.Matches = append(r.Matches, RedactionMatch{Rule: rule.name, Text: text[m[0]:m[1]]})
+			}
+		}
+	}
+
+	for _, page := range layout {
+		pageBoxes := boxes[page.Number]
+		if len(pageBoxes) == 0 {
+			continue
+		}
+		r := report(page.Number)
+		r.Boxes = len(pageBoxes)
+		for _, line := range page.Lines {
+			for _, w := range line.Words {
+				wb := redactBox{w.XMin, w.YMin, w.XMax, w.YMax}
+				for _, b := range pageBoxes {
+					if wb.intersects(b) {
+						r.WordsRemoved++
+						break
+					}
+				}
+			}
+		}
+	}
+	return boxes, reports
+}
+
+// annotationText gathers the text an annotation carries: its contents,
+// rich text, subject and author, and for a form widget the name, tooltip,
+// caption and value of the field it belongs to.
+func annotationText(ctx *model.Context, d types.Dict) []string {
+	var texts []string
+	var add func(o types.Object)
+	add = func(o types.Object) {
+		o, err := ctx.Dereference(o)
+		if err != nil || o == nil {
+			return
+		}
+		switch o := o.(type) {
+		case types.Name:
+			texts = append(texts, string(o))
+		case types.Array:
+			for _, e := range o {
+				add(e)
+			}
+		default:
+			if s, err := ctx.DereferenceStringOrHexLiteral(o, model.V10, nil); err == nil && s != "" {
+				texts = append(texts, s)
+			}
+		}
+	}
+
+	for _, key := range []string{"Contents", "RC", "Subj", "T"} {
+		add(d[key])
+	}
+	if st := d.Subtype(); st == nil || *st != "Widget" {
+		return texts
+	}
+	if mk, err := ctx.DereferenceDict(d["MK"]); err == nil && mk != nil {
+		add(mk["CA"])
+	}
+	// Field attributes are inherited, so look up the field hierarchy too.
+	for i, field := 0, d; field != nil && i < 32; i++ {
+		for _, key := range []string{"TU", "V", "DV", "Opt"} {
+			add(field[key])
+		}
+		if i > 0 {
+			add(field["T"])
+		}
+		field, _ = ctx.DereferenceDict(field["Parent"])
+	}
+	return texts
+}
+
+// topLevelField returns the object number of the field at the root of the
+// hierarchy a widget belongs to, or 0 if it is not an indirect object.
+func topLevelField(ctx *model.Context, widget types.Object) int {
+	nr := 0
+	for i := 0; i < 32; i++ {
+		ir, ok := widget.(types.IndirectRef)
+		if !ok {
+			break
+		}
+		nr = ir.ObjectNumber.Value()
+		d, err := ctx.DereferenceDict(ir)
+		if err != nil || d == nil {
+			break
+		}
+		if widget, ok = d["Parent"]; !ok {
+			break
+		}
+	}
+	return nr
+}
+
+// redactAnnotations removes every annotation whose text matches a rule from
+// the PDF at path, together with its popup. A matching form widget takes
+// its whole field along, including the field's other widgets. This text is
+// not part of the page content, so neither the search nor the rendered
+// pages would otherwise get rid of it.
+func redactAnnotations(path string, rules []redactRule) (map[int]*RedactionPageReport, error) {
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	type annotRef struct{ page, index int }
+	reports := map[int]*RedactionPageReport{}
+	drop := map[annotRef]bool{}
+	removedAnnots := map[int]bool{}
+	removedFields := map[int]bool{}
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil {
+			continue
+		}
+		annots, err := ctx.DereferenceArray(pageDict["Annots"])
+		if err != nil {
+			continue
+		}
+		for i, o := range annots {
+			d, err := ctx.DereferenceDict(o)
+			if err != nil || d == nil {
+				continue
+			}
+			var matches []RedactionMatch
+			for _, text := range annotationText(ctx, d) {
+				for _, rule := range rules {
+					for _, m := range rule.re.FindAllString(text, -1) {
+						if m != "" {
+							matches = append(matches, RedactionMatch{Rule: rule.name, Text: m})
+						}
+					}
+				}
+			}
+			if len(matches) == 0 {
+				continue
+			}
+
+			if reports[pageNr] == nil {
+				reports[pageNr] = &RedactionPageReport{Page: pageNr}
+			}
+			reports[pageNr].Matches = append(reports[pageNr].Matches, matches...)
+			drop[annotRef{pageNr, i}] = true
+			if ir, ok := o.(types.IndirectRef); ok {
+				removedAnnots[ir.ObjectNumber.Value()] = true
+			}
+			if st := d.Subtype(); st != nil && *st == "Widget" {
+				if nr := topLevelField(ctx, o); nr > 0 {
+					removedFields[nr] = true
+				}
+			}
+		}
+	}
+	if len(drop) == 0 {
+		return reports, nil
+	}
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil {
+			continue
+		}
+		annots, err := ctx.DereferenceArray(pageDict["Annots"])
+		if err != nil || len(annots) == 0 {
+			continue
+		}
+
+		var kept types.Array
+		for i, o := range annots {
+			remove := drop[annotRef{pageNr, i}]
+			if d, err := ctx.DereferenceDict(o); !remove && err == nil && d != nil {
+				st := d.Subtype()
+				if st != nil && *st == "Popup" {
+					parent, ok := d["Parent"].(types.IndirectRef)
+					remove = ok && removedAnnots[parent.ObjectNumber.Value()]
+				} else if st != nil && *st == "Widget" {
+					remove = removedFields[topLevelField(ctx, o)]
+				}
+			}
+			if !remove {
+				kept = append(kept, o)
+			}
+		}
+		if removed := len(annots) - len(kept); removed > 0 {
+			if reports[pageNr] == nil {
+				reports[pageNr] = &RedactionPageReport{Page: pageNr}
+			}
+			reports[pageNr].AnnotationsRemoved = removed
+			if len(kept) == 0 {
+				pageDict.Delete("Annots")
+			} else {
+				pageDict["Annots"] = kept
+			}
+		}
+	}
+
+	if len(removedFields) > 0 {
+		if root, err := ctx.Catalog(); err == nil {
+			if form, err := ctx.DereferenceDict(root["AcroForm"]); err == nil && form != nil {
+				if fields, err := ctx.DereferenceArray(form["Fields"]); err == nil {
+					var kept types.Array
+					for _, o := range fields {
+						if ir, ok := o.(types.IndirectRef); ok && removedFields[ir.ObjectNumber.Value()] {
+							continue
+						}
+						kept = append(kept, o)
+					}
+					form["Fields"] = kept
+				}
+				// XFA keeps its own copy of the form data.
+				form.Delete("XFA")
+			}
+		}
+	}
+
+	return reports, api.WriteContextFile(ctx, path)
+}
+
+// redactPage renders a page, paints the boxes over the pixels and turns the
+// image back into a single-page PDF of the same size. Nothing of the
+// original page content survives, so text, images and vector art under the
+// boxes cannot be recovered.
+func redactPage(ctx context.Context, inPath string, page utils.TextPage, boxes []redactBox, dpi int, workDir string) (string, error) {
+	prefix := filepath.Join(workDir, "page-"+strconv.Itoa(page.Number))
+	pngPath, err := utils.RenderPage(ctx, inPath, page.Number, dpi, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(pngPath)
+	if err != nil {
+		return "", err
+	}
+	src, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to decode page %d: %v", page.Number, err)
+	}
+
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	sx := float64(img.Bounds().Dx()) / page.Width
+	sy := float64(img.Bounds().Dy()) / page.Height
+	for _, b := range boxes {
+		rect := image.Rect(int(b.x0*sx), int(b.y0*sy), int(b.x1*sx+0.5), int(b.y1*sy+0.5))
+		draw.Draw(img, rect.Intersect(img.Bounds()), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+
+	out, err := os.Create(pngPath)
+	if err != nil {
+		return "", err
+	}
+	if err := png.Encode(out, img); err != nil {
+		out.Close()
+		return "", err
+	}
+	out.Close()
+
+	pdfPath := prefix + ".pdf"
+	if err := imagePagePDF(pngPath, pdfPath, page.Width, page.Height); err != nil {
+		return "", fmt.Errorf("failed to rebuild page %d: %v", page.Number, err)
+	}
+	return pdfPath, nil
+}
+
+// imagePagePDF writes a single-page PDF of the given size in points with
+// the image stretched over the whole page.
+func imagePagePDF(imagePath, pdfPath string, width, height float64) error {
+	imp := pdfcpu.DefaultImportConfig()
+	imp.PageDim = &types.Dim{Width: width, Height: height}
+	imp.PageSize = ""
+	imp.UserDim = true
+	imp.Pos = types.Center
+	imp.Scale = 1
+
+	return api.ImportImagesFile([]string{imagePath}, pdfPath, imp, nil)
+}
+
+// scrubMetadata drops the document info, XMP streams and private
+// application data that could still carry redacted text.
+func scrubMetadata(path string) error {
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		return err
+	}
+
+	ctx.Info = nil
+	if root, err := ctx.Catalog(); err == nil {
+		root.Delete("Metadata")
+		root.Delete("PieceInfo")
+	}
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil || pageDict == nil {
+			continue
+		}
+		pageDict.Delete("Metadata")
+		pageDict.Delete("PieceInfo")
+	}
+
+	return api.WriteContextFile(ctx, path)
+}
+
+func RedactHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[RedactHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+
+	var req RedactRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+	rules, err := req.rules()
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rules) == 0 && len(req.Regions) == 0 {
+		jsonError(w, "Nothing to redact. Provide 'regions', 'terms', 'patterns' or 'presets'", http.StatusBadRequest)
+		return
+	}
+	if req.DPI <= 0 {
+		req.DPI = defaultRedactDPI
+	}
+	if req.DPI > maxRedactDPI {
+		jsonError(w, fmt.Sprintf("'dpi' must not exceed %d", maxRedactDPI), http.StatusBadRequest)
+		return
+	}
+
+	inputPath, ok := saveUploadedPDF(w, r, "redact-in-*.pdf")
+	if !ok {
+		return
+	}
+	defer os.Remove(inputPath)
+
+	// Annotations and form fields go first, so that neither the rendered
+	// pages nor the pages copied as they are still show them.
+	annotReports := map[int]*RedactionPageReport{}
+	if len(rules) > 0 {
+		annotReports, err = redactAnnotations(inputPath, rules)
+		if err != nil {
+			fmt.Println("[RedactHandler] ❌ Annotation redaction failed:", err)
+			jsonError(w, "Failed to read PDF: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	layout, err := utils.ExtractTextLayout(r.Context(), inputPath)
+	if err != nil {
+		fmt.Println("[RedactHandler] ❌ Text extraction failed:", err)
+		jsonError(w, "Failed to read PDF text: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	for _, reg := range req.Regions {
+		if reg.Page < 1 || reg.Page > len(layout) {
+			jsonError(w, fmt.Sprintf("Region page %d out of range (1-%d)", reg.Page, len(layout)), http.StatusBadRequest)
+			return
+		}
+		if reg.Width <= 0 || reg.Height <= 0 {
+			jsonError(w, fmt.Sprintf("Region on page %d must have a positive width and height", reg.Page), http.StatusBadRequest)
+			return
+		}
+	}
+
+	boxes, reports := findRedactions(layout, req.Regions, rules)
+	for page, ar := range annotReports {
+		if reports[page] == nil {
+			reports[page] = &RedactionPageReport{Page: page}
+		}
+		reports[page].AnnotationsRemoved = ar.AnnotationsRemoved
+		reports[page].Matches = append(reports[page].Matches, ar.Matches...)
+	}
+
+	workDir, err := os.MkdirTemp("", "redact-*")
+	if err != nil {
+		jsonError(w, "Failed to create temp dir", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	// Rebuild the document from pages only: redacted pages are replaced by
+	// their images, the rest are copied as they are. Starting from an empty
+	// file leaves outlines, names and other document-level data behind.
+	args := []string{"--empty", "--pages"}
+	for _, page := range layout {
+		if len(boxes[page.Number]) == 0 {
+			args = append(args, inputPath, strconv.Itoa(page.Number))
+			continue
+		}
+		pagePath, err := redactPage(r.Context(), inputPath, page, boxes[page.Number], req.DPI, workDir)
+		if err != nil {
+			fmt.Println("[RedactHandler] ❌ Redaction failed:", err)
+			jsonError(w, "Failed to redact page: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		args = append(args, pagePath, "1")
+	}
+	outputPath := filepath.Join(workDir, "redacted.pdf")
+	args = append(args, "--", outputPath)
+
+	if _, err := utils.RunQPDF(r.Context(), args...); err != nil {
+		fmt.Println("[RedactHandler] ❌ Assembly failed:", err)
+		jsonError(w, "Failed to assemble redacted PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := scrubMetadata(outputPath); err != nil {
+		fmt.Println("[RedactHandler] ❌ Metadata scrub failed:", err)
+		jsonError(w, "Failed to scrub metadata: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pages := make([]RedactionPageReport, 0, len(reports))
+	for _, rep := range reports {
+		pages = append(pages, *rep)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Page < pages[j].Page })
+	fmt.Printf("[RedactHandler] ✅ Redacted %d page(s)\n", len(pages))
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open redacted PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("redacted/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":   url,
+		"pages": pages,
+	})
+
+	fmt.Println("[RedactHandler] ✅ Done in", time.Since(start))
+}
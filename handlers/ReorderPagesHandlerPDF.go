@@ -10,13 +10,17 @@ import (
 	"slices"
 	"time"
 
+	"github.com/Lucifer7355/PDF/pageselect"
 	"github.com/Lucifer7355/PDF/utils"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 )
 
 type ReorderPagesRequest struct {
-	Order []int `json:"order"`
+	// Order lists pages in their new order; unlisted pages follow in
+	// document order, and a page listed twice keeps its first position.
+	// Left empty, the document keeps its order.
+	Order pageselect.Selection `json:"order"`
 }
 
 func jsonError6(w http.ResponseWriter, msg string, code int) {
@@ -95,10 +99,16 @@ func ReorderPagesHandler(w http.ResponseWriter, r *http.Request) {
 	totalPages := ctx.PageCount
 	fmt.Printf("[ReorderPagesHandler] 📄 Total pages in input PDF: %d\n", totalPages)
 
+	order, err := req.Order.PagesOrAll(totalPages)
+	if err != nil {
+		jsonError6(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Merge input order and missing pages
 	ordered := make(map[int]bool)
-	finalOrder := slices.Clone(req.Order)
-	for _, i := range req.Order {
+	finalOrder := slices.Clone(order)
+	for _, i := range order {
 		ordered[i] = true
 	}
 	for i := 1; i <= totalPages; i++ {
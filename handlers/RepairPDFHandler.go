@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// RepairReport describes what repairPDF had to do to make a file readable.
+type RepairReport struct {
+	WasValid       bool     `json:"wasValid"`       // the input already parsed cleanly
+	Method         string   `json:"method"`         // "none", "qpdf" or "ghostscript"
+	Issues         []string `json:"issues"`         // problems found and worked around
+	PagesRecovered int      `json:"pagesRecovered"` // pages in the repaired output
+}
+
+func relaxedConfig() *model.Configuration {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	return conf
+}
+
+// repairPDF writes a readable version of inPath to outPath. qpdf is tried
+// first since it rebuilds damaged xref tables and streams while keeping the
+// document structure; ghostscript re-rendering is the fallback and recovers
+// whatever pages it can interpret.
+func repairPDF(ctx context.Context, inPath, outPath string) (RepairReport, error) {
+	report := RepairReport{Method: "none"}
+
+	validateErr := api.ValidateFile(inPath, relaxedConfig())
+	if validateErr == nil {
+		report.WasValid = true
+		data, err := os.ReadFile(inPath)
+		if err != nil {
+			return report, err
+		}
+		if err := os.WriteFile(outPath, data, 0644); err != nil {
+			return report, err
+		}
+		report.PagesRecovered, err = api.PageCountFile(outPath)
+		return report, err
+	}
+	report.Issues = append(report.Issues, "pdfcpu: "+validateErr.Error())
+
+	warnings, err := utils.RunQPDF(ctx, inPath, outPath)
+	if err == nil && api.ValidateFile(outPath, relaxedConfig()) == nil {
+		report.Method = "qpdf"
+		report.Issues = append(report.Issues, warnings...)
+		report.PagesRecovered, err = api.PageCountFile(outPath)
+		return report, err
+	}
+	if err != nil {
+		report.Issues = append(report.Issues, err.Error())
+	}
+	os.Remove(outPath)
+
+	out, err := utils.RunGhostscript(ctx,
+		"-sDEVICE=pdfwrite",
+		"-dPDFSTOPONERROR=false",
+		"-sOutputFile="+outPath,
+		"-f", inPath,
+	)
+	if err != nil {
+		return report, fmt.Errorf("ghostscript failed: %v: %s", err, out)
+	}
+	if err := api.ValidateFile(outPath, relaxedConfig()); err != nil {
+		return report, fmt.Errorf("repaired file is still unreadable: %v", err)
+	}
+
+	report.Method = "ghostscript"
+	report.PagesRecovered, err = api.PageCountFile(outPath)
+	return report, err
+}
+
+func RepairHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[RepairHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		jsonError(w, "Missing 'file' field", http.StatusBadRequest)
+		return
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		jsonError(w, "Unable to read uploaded file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	inputTmp, err := os.CreateTemp("", "repair-in-*.pdf")
+	if err != nil {
+		jsonError(w, "Failed to create temp file", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(inputTmp.Name())
+
+	if _, err := io.Copy(inputTmp, file); err != nil {
+		inputTmp.Close()
+		jsonError(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	inputTmp.Close()
+
+	outputPath := inputTmp.Name() + "-repaired.pdf"
+	defer os.Remove(outputPath)
+
+	report, err := repairPDF(r.Context(), inputTmp.Name(), outputPath)
+	if err != nil {
+		fmt.Println("[RepairHandler] ❌ Repair failed:", err)
+		jsonError(w, "Unable to repair PDF: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Printf("[RepairHandler] ✅ Repaired with %s, %d pages recovered\n", report.Method, report.PagesRecovered)
+
+	outFile, err := os.Open(outputPath)
+	if err != nil {
+		jsonError(w, "Failed to open repaired PDF", http.StatusInternalServerError)
+		return
+	}
+	defer outFile.Close()
+
+	uploadKey := fmt.Sprintf("repaired/%d.pdf", time.Now().UnixNano())
+	url, err := utils.UploadStreamToR2(uploadKey, outFile)
+	if err != nil {
+		jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":    url,
+		"report": report,
+	})
+
+	fmt.Println("[RepairHandler] ✅ Done in", time.Since(start))
+}
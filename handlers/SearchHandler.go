@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/color"
+)
+
+type SearchRequest struct {
+	Query      string `json:"query"`
+	Regex      bool   `json:"regex"` // treat the query as a regular expression instead of literal text
+	IgnoreCase bool   `json:"ignoreCase"`
+	WholeWord  bool   `json:"wholeWord"`
+	Context    int    `json:"context"`   // characters of surrounding text on each side of a snippet, default 40
+	MaxHits    int    `json:"maxHits"`   // per file, default 1000
+	Highlight  bool   `json:"highlight"` // also return a copy of each PDF with the hits highlighted
+}
+
+type SearchHit struct {
+	Page    int    `json:"page"`
+	Text    string `json:"text"`
+	Snippet string `json:"snippet"`
+	// Boxes holds one rectangle per line the hit spans, in the form /redact
+	// accepts as regions.
+	Boxes []RedactRegion `json:"boxes"`
+}
+
+type SearchFileResult struct {
+	Filename  string      `json:"filename"`
+	HitCount  int         `json:"hitCount"`
+	Pages     []int       `json:"pages"` // pages with at least one hit
+	Truncated bool        `json:"truncated,omitempty"`
+	Hits      []SearchHit `json:"hits"`
+	URL       string      `json:"url,omitempty"` // highlighted copy
+	Error     string      `json:"error,omitempty"`
+}
+
+const (
+	defaultSearchContext = 40
+	defaultSearchMaxHits = 1000
+)
+
+var searchHighlightColor = color.SimpleColor{R: 1, G: .9, B: .3}
+
+// searchPattern compiles the query. Literal queries match across any run
+// of whitespace, so a phrase is found even where it wraps onto a new line.
+func (req SearchRequest) searchPattern() (*regexp.Regexp, error) {
+	expr := req.Query
+	if !req.Regex {
+		words := strings.Fields(req.Query)
+		for i, w := range words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		expr = strings.Join(words, `\s+`)
+	}
+	if req.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %v", err)
+	}
+	if re.MatchString("") {
+		return nil, errors.New("the query matches empty text")
+	}
+	return re, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// wholeWordAt reports whether text[from:to] is not glued to letters or
+// digits on either side.
+func wholeWordAt(text string, from, to int) bool {
+	if r, _ := utf8.DecodeLastRuneInString(text[:from]); from > 0 && isWordRune(r) {
+		return false
+	}
+	if r, _ := utf8.DecodeRuneInString(text[to:]); to < len(text) && isWordRune(r) {
+		return false
+	}
+	return true
+}
+
+// searchSnippet cuts context bytes of text either side of a match, widened
+// to the nearest spaces so that no word is cut in half.
+func searchSnippet(text string, from, to, context int) string {
+	start, end := max(0, from-context), min(len(text), to+context)
+	if start > 0 {
+		if i := strings.LastIndexByte(text[:start], ' '); i >= 0 {
+			start = i + 1
+		} else {
+			start = 0
+		}
+	}
+	if end < len(text) {
+		if i := strings.IndexByte(text[end:], ' '); i >= 0 {
+			end += i
+		} else {
+			end = len(text)
+		}
+	}
+	snippet := text[start:end]
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(text) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// matchIndexes returns the non-empty matches of re in text. With
+// wholeWord, a match glued to a neighbouring word is rejected and the
+// search resumes one character after its start, so that an overlapping
+// match that does stand on its own is still found.
+func matchIndexes(re *regexp.Regexp, text string, wholeWord bool) [][]int {
+	if !wholeWord {
+		var out [][]int
+		for _, m := range re.FindAllStringIndex(text, -1) {
+			if m[0] != m[1] {
+				out = append(out, m)
+			}
+		}
+		return out
+	}
+
+	var out [][]int
+	for pos := 0; pos < len(text); {
+		m := re.FindStringIndex(text[pos:])
+		if m == nil {
+			break
+		}
+		from, to := pos+m[0], pos+m[1]
+		if from != to && wholeWordAt(text, from, to) {
+			out = append(out, []int{from, to})
+			pos = to
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[from:])
+		pos = from + max(size, 1)
+	}
+	return out
+}
+
+type searchMatch struct {
+	hit   SearchHit
+	words []layoutWord
+}
+
+// searchLayout runs the pattern over the text of every page, with the
+// words of a page joined by single spaces. It stops after maxHits matches
+// and reports whether there were more.
+func searchLayout(layout []utils.TextPage, re *regexp.Regexp, req SearchRequest) ([]searchMatch, bool) {
+	var matches []searchMatch
+	line := 0
+	for _, page := range layout {
+		var words []layoutWord
+		var starts []int
+		var b strings.Builder
+		for _, l := range page.Lines {
+			line++
+			for _, w := range l.Words {
+				if b.Len() > 0 {
+					b.WriteByte(' ')
+				}
+				starts = append(starts, b.Len())
+				b.WriteString(w.Text)
+				words = append(words, layoutWord{text: w.Text, page: page.Number, line: line, box: w})
+			}
+		}
+		text := b.String()
+
+		for _, m := range matchIndexes(re, text, req.WholeWord) {
+			if len(matches) == req.MaxHits {
+				return matches, true
+			}
+
+			sm := searchMatch{hit: SearchHit{
+				Page:    page.Number,
+				Text:    text[m[0]:m[1]],
+				Snippet: searchSnippet(text, m[0], m[1], req.Context),
+			}}
+			for i, w := range words {
+				if starts[i] < m[1] && m[0] < starts[i]+len(w.text) {
+					sm.words = append(sm.words, w)
+				}
+			}
+			lineBoxes(sm.words, func(_ int, box utils.TextWord) {
+				sm.hit.Boxes = append(sm.hit.Boxes, RedactRegion{
+					Page:   page.Number,
+					X:      box.XMin,
+					Y:      page.Height - box.YMax,
+					Width:  box.XMax - box.XMin,
+					Height: box.YMax - box.YMin,
+				})
+			})
+			matches = append(matches, sm)
+		}
+	}
+	return matches, false
+}
+
+// highlightHits writes a copy of the PDF at path with every match marked.
+func highlightHits(path, outPath string, matches []searchMatch) error {
+	ctx, err := api.ReadContextFile(path)
+	if err != nil {
+		return err
+	}
+	mb := newMarkupBuilder(ctx, "Search")
+	for _, m := range matches {
+		if err := mb.highlight(m.words, &searchHighlightColor, m.hit.Text, "Search hit"); err != nil {
+			return err
+		}
+	}
+	if _, err := pdfcpu.AddAnnotationsMap(ctx, mb.annots, false); err != nil {
+		return err
+	}
+	return api.WriteContextFile(ctx, outPath)
+}
+
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fmt.Println("[SearchHandler] ➜ Received request at", start.Format(time.RFC3339))
+
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		jsonError(w, "Invalid multipart form data", http.StatusBadRequest)
+		return
+	}
+
+	meta := r.FormValue("meta")
+	if meta == "" {
+		jsonError(w, "Missing 'meta' field", http.StatusBadRequest)
+		return
+	}
+	var req SearchRequest
+	if err := json.Unmarshal([]byte(meta), &req); err != nil {
+		jsonError(w, "Invalid JSON in 'meta'", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		jsonError(w, "Missing 'query'", http.StatusBadRequest)
+		return
+	}
+	re, err := req.searchPattern()
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Context <= 0 {
+		req.Context = defaultSearchContext
+	}
+	if req.MaxHits <= 0 {
+		req.MaxHits = defaultSearchMaxHits
+	}
+
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		files = r.MultipartForm.File["file"]
+	}
+	if len(files) == 0 {
+		jsonError(w, "Please upload at least one PDF in 'files'", http.StatusBadRequest)
+		return
+	}
+
+	batch := time.Now().UnixNano()
+	results := make([]SearchFileResult, len(files))
+	totalHits, failed := 0, 0
+	for i, fh := range files {
+		res := &results[i]
+		res.Filename = fh.Filename
+		res.Pages, res.Hits = []int{}, []SearchHit{}
+
+		path, err := saveUploadedFile(fh, "search-in-*.pdf")
+		if err != nil {
+			jsonError(w, "Failed to save uploaded file", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(path)
+
+		layout, err := utils.ExtractTextLayout(r.Context(), path)
+		if err != nil {
+			fmt.Printf("[SearchHandler] ❌ Text extraction failed for %s: %v\n", fh.Filename, err)
+			res.Error = "Failed to read PDF text: " + err.Error()
+			failed++
+			continue
+		}
+
+		matches, truncated := searchLayout(layout, re, req)
+		res.HitCount, res.Truncated = len(matches), truncated
+		totalHits += len(matches)
+		for _, m := range matches {
+			res.Hits = append(res.Hits, m.hit)
+			if n := len(res.Pages); n == 0 || res.Pages[n-1] != m.hit.Page {
+				res.Pages = append(res.Pages, m.hit.Page)
+			}
+		}
+
+		if !req.Highlight || len(matches) == 0 {
+			continue
+		}
+		outPath := path + "-highlighted.pdf"
+		defer os.Remove(outPath)
+		if err := highlightHits(path, outPath, matches); err != nil {
+			fmt.Printf("[SearchHandler] ❌ Highlighting failed for %s: %v\n", fh.Filename, err)
+			res.Error = "Failed to highlight hits: " + err.Error()
+			continue
+		}
+
+		name := safeFilename(strings.TrimSuffix(fh.Filename, filepath.Ext(fh.Filename)))
+		if name == "" {
+			name = "file-" + strconv.Itoa(i+1)
+		}
+		outFile, err := os.Open(outPath)
+		if err != nil {
+			jsonError(w, "Failed to open output PDF", http.StatusInternalServerError)
+			return
+		}
+		res.URL, err = utils.UploadStreamToR2(fmt.Sprintf("search/%d/%d-%s.pdf", batch, i+1, name), outFile)
+		outFile.Close()
+		if err != nil {
+			jsonError(w, "Failed to upload to R2", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if failed == len(files) {
+		jsonError(w, results[0].Error, http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Printf("[SearchHandler] ✅ %d hit(s) in %d file(s)\n", totalHits, len(files))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":     req.Query,
+		"totalHits": totalHits,
+		"files":     results,
+	})
+
+	fmt.Println("[SearchHandler] ✅ Done in", time.Since(start))
+}
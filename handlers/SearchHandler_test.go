@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestMatchIndexes(t *testing.T) {
+	tests := []struct {
+		expr      string
+		text      string
+		wholeWord bool
+		want      [][]int
+	}{
+		{"cat", "cat concat cat", false, [][]int{{0, 3}, {7, 10}, {11, 14}}},
+		{"cat", "cat concat cat", true, [][]int{{0, 3}, {11, 14}}},
+		{"cat", "concatenate", true, nil},
+		{"x*", "axb", false, [][]int{{1, 2}}},
+		{`a\s+b`, "xa b a b", true, [][]int{{5, 8}}},
+		// "1 2" is glued to "x"; the overlapping "2 3" stands on its own
+		// and must still be found.
+		{`\d+ \d+`, "x1 2 3", true, [][]int{{3, 6}}},
+		{`aa|a`, "baa a", true, [][]int{{4, 5}}},
+		{"é", "éé é", true, [][]int{{5, 7}}},
+	}
+	for _, tt := range tests {
+		got := matchIndexes(regexp.MustCompile(tt.expr), tt.text, tt.wholeWord)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchIndexes(%q, %q, %v) = %v, want %v", tt.expr, tt.text, tt.wholeWord, got, tt.want)
+		}
+	}
+}
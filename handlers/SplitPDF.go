@@ -12,19 +12,37 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Lucifer7355/PDF/pageselect"
 	"github.com/Lucifer7355/PDF/utils"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
 type SplitRequest struct {
-	Mode   string   `json:"mode"`   // "range" or "count"
-	Ranges []string `json:"ranges"` // used if mode == "range"
-	Count  int      `json:"count"`  // used if mode == "count"
+	Mode           string   `json:"mode"`           // "range", "count", "bookmark", "size", "blank-separator" or "pattern"
+	Ranges         []string `json:"ranges"`         // used if mode == "range": one page selection per entry
+	Count          int      `json:"count"`          // used if mode == "count"
+	MaxBytes       int64    `json:"maxBytes"`       // used if mode == "size"
+	BlankThreshold float64  `json:"blankThreshold"` // used if mode == "blank-separator": ink coverage in percent, default 0.3
+	Pattern        string   `json:"pattern"`        // used if mode == "pattern": regex whose first group names each part
+}
+
+// defaultBlankThreshold is the ink coverage, in percent of the page, below
+// which a page counts as blank. Scanned blank sheets pick up some noise
+// from dust and paper edges, so it is not zero.
+const defaultBlankThreshold = 0.3
+
+// splitPart is a run of pages written to its own file.
+type splitPart struct {
+	Name     string
+	Match    string // pattern mode: the text the part was named after
+	From, To int
 }
 
 type FileUpload struct {
 	Filename string `json:"filename"`
 	URL      string `json:"url"`
+	Match    string `json:"match,omitempty"` // split by pattern: the captured text
+	Pages    string `json:"pages,omitempty"` // split by pattern: pages of the original in this part
 }
 
 type ErrorResponse struct {
@@ -37,46 +55,6 @@ func jsonError(w http.ResponseWriter, msg string, code int) {
 	json.NewEncoder(w).Encode(ErrorResponse{Error: msg})
 }
 
-func normalizeRanges(textualRanges []string) []string {
-	var output []string
-	rangePattern := regexp.MustCompile(`(?i)page(?:s)?\s+(\d+)(?:\s*(?:to|through|-)\s*(\d+))?`)
-	for _, raw := range textualRanges {
-		raw = strings.TrimSpace(raw)
-		matches := rangePattern.FindAllStringSubmatch(raw, -1)
-		for _, m := range matches {
-			from := m[1]
-			to := m[2]
-			if to == "" {
-				output = append(output, from)
-			} else {
-				output = append(output, from+"-"+to)
-			}
-		}
-	}
-	return output
-}
-
-func validateRanges(ranges []string, totalPages int) error {
-	for _, r := range ranges {
-		parts := strings.Split(r, "-")
-		if len(parts) == 1 {
-			page, err := strconv.Atoi(parts[0])
-			if err != nil || page < 1 || page > totalPages {
-				return fmt.Errorf("Invalid page number: %s (out of bounds)", r)
-			}
-		} else if len(parts) == 2 {
-			from, err1 := strconv.Atoi(parts[0])
-			to, err2 := strconv.Atoi(parts[1])
-			if err1 != nil || err2 != nil || from < 1 || to > totalPages || from > to {
-				return fmt.Errorf("Invalid range: %s (out of bounds or reversed)", r)
-			}
-		} else {
-			return fmt.Errorf("Invalid range format: %s", r)
-		}
-	}
-	return nil
-}
-
 func SplitHandler(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	fmt.Println("[SplitHandler] ➜ Received request at", start.Format(time.RFC3339))
@@ -127,6 +105,7 @@ func SplitHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer os.RemoveAll(outputDir)
 
+	partInfo := map[string]splitPart{} // file name -> part, where the part needs reporting
 	switch req.Mode {
 	case "count":
 		if req.Count <= 0 {
@@ -141,28 +120,108 @@ func SplitHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		normalized := normalizeRanges(req.Ranges)
-
-		pageCount, err := api.PageCountFile(inputTmp.Name())
-		if err != nil {
+		pageCount, countErr := api.PageCountFile(inputTmp.Name())
+		if countErr != nil {
 			jsonError(w, "Failed to read PDF page count", http.StatusInternalServerError)
 			return
 		}
 
-		if err := validateRanges(normalized, pageCount); err != nil {
-			jsonError(w, err.Error(), http.StatusBadRequest)
-			return
+		var selections [][]int
+		for _, rng := range req.Ranges {
+			pages, parseErr := pageselect.Parse(rng, pageCount)
+			if parseErr != nil {
+				jsonError(w, parseErr.Error(), http.StatusBadRequest)
+				return
+			}
+			selections = append(selections, pages)
 		}
 
-		for _, r := range normalized {
-			err = api.ExtractPagesFile(inputTmp.Name(), outputDir, []string{r}, nil)
+		for _, pages := range selections {
+			err = api.ExtractPagesFile(inputTmp.Name(), outputDir, pageselect.Format(pages), nil)
 			if err != nil {
 				break
 			}
 		}
 
+	case "bookmark":
+		pageCount, countErr := api.PageCountFile(inputTmp.Name())
+		if countErr != nil {
+			jsonError(w, "Failed to read PDF page count", http.StatusInternalServerError)
+			return
+		}
+		bookmarks, readErr := readBookmarks(inputTmp.Name())
+		if readErr != nil {
+			jsonError(w, "Failed to read bookmarks: "+readErr.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		parts := bookmarkParts(bookmarks, pageCount)
+		if len(parts) == 0 {
+			jsonError(w, "The PDF has no bookmarks to split at", http.StatusBadRequest)
+			return
+		}
+		err = writeSplitParts(inputTmp.Name(), outputDir, parts)
+
+	case "size":
+		if req.MaxBytes <= 0 {
+			jsonError(w, "Invalid 'maxBytes' value", http.StatusBadRequest)
+			return
+		}
+		err = splitBySize(inputTmp.Name(), outputDir, req.MaxBytes)
+
+	case "blank-separator":
+		if req.BlankThreshold <= 0 {
+			req.BlankThreshold = defaultBlankThreshold
+		}
+		pageCount, countErr := api.PageCountFile(inputTmp.Name())
+		if countErr != nil {
+			jsonError(w, "Failed to read PDF page count", http.StatusInternalServerError)
+			return
+		}
+		coverage, inkErr := utils.InkCoverage(r.Context(), inputTmp.Name())
+		if inkErr != nil {
+			jsonError(w, "Failed to analyse pages: "+inkErr.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if len(coverage) != pageCount {
+			jsonError(w, fmt.Sprintf("Page analysis covered %d of %d pages", len(coverage), pageCount), http.StatusInternalServerError)
+			return
+		}
+		parts := blankSeparatedParts(coverage, req.BlankThreshold/100)
+		if len(parts) == 0 {
+			jsonError(w, "Every page of the PDF is blank", http.StatusBadRequest)
+			return
+		}
+		fmt.Printf("[SplitHandler] 📄 Found %d document(s) between blank pages\n", len(parts))
+		err = writeSplitParts(inputTmp.Name(), outputDir, parts)
+
+	case "pattern":
+		if req.Pattern == "" {
+			jsonError(w, "No 'pattern' provided", http.StatusBadRequest)
+			return
+		}
+		re, reErr := regexp.Compile(req.Pattern)
+		if reErr != nil {
+			jsonError(w, "Invalid 'pattern': "+reErr.Error(), http.StatusBadRequest)
+			return
+		}
+		layout, layoutErr := utils.ExtractTextLayout(r.Context(), inputTmp.Name())
+		if layoutErr != nil {
+			jsonError(w, "Failed to read PDF text: "+layoutErr.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		parts := patternParts(layout, re)
+		if len(parts) == 0 {
+			jsonError(w, "No page matches 'pattern'", http.StatusBadRequest)
+			return
+		}
+		for i, p := range parts {
+			partInfo[splitPartFilename(i, p)] = p
+		}
+		fmt.Printf("[SplitHandler] 📄 Found %d document(s) by pattern\n", len(parts))
+		err = writeSplitParts(inputTmp.Name(), outputDir, parts)
+
 	default:
-		jsonError(w, "Invalid split mode. Use 'range' or 'count'", http.StatusBadRequest)
+		jsonError(w, "Invalid split mode. Use 'range', 'count', 'bookmark', 'size', 'blank-separator' or 'pattern'", http.StatusBadRequest)
 		return
 	}
 
@@ -177,6 +236,8 @@ func SplitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Part names repeat between requests, so each request gets its own prefix.
+	batch := time.Now().UnixNano()
 	var uploads []FileUpload
 	for _, f := range files {
 		path := filepath.Join(outputDir, f.Name())
@@ -186,15 +247,20 @@ func SplitHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		defer splitFile.Close()
 
-		url, err := utils.UploadStreamToR2("split/"+f.Name(), splitFile)
+		url, err := utils.UploadStreamToR2(fmt.Sprintf("split/%d/%s", batch, f.Name()), splitFile)
 		if err != nil {
 			continue
 		}
 
-		uploads = append(uploads, FileUpload{
+		upload := FileUpload{
 			Filename: f.Name(),
 			URL:      url,
-		})
+		}
+		if p, ok := partInfo[f.Name()]; ok {
+			upload.Match = p.Match
+			upload.Pages = fmt.Sprintf("%d-%d", p.From, p.To)
+		}
+		uploads = append(uploads, upload)
 		fmt.Printf("[SplitHandler] ✅ Uploaded split part: %s\n", url)
 	}
 
@@ -206,3 +272,232 @@ func SplitHandler(w http.ResponseWriter, r *http.Request) {
 	})
 	fmt.Println("[SplitHandler] ✅ Split & upload done in", time.Since(start))
 }
+
+// writeSplitParts writes each part to outputDir. File names are numbered so
+// that the parts sort in document order.
+func writeSplitParts(inPath, outputDir string, parts []splitPart) error {
+	for i, p := range parts {
+		outPath := filepath.Join(outputDir, splitPartFilename(i, p))
+		if err := api.TrimFile(inPath, outPath, []string{fmt.Sprintf("%d-%d", p.From, p.To)}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitPartFilename(i int, p splitPart) string {
+	name := fmt.Sprintf("%03d", i+1)
+	if p.Name != "" {
+		name += "_" + p.Name
+	}
+	return name + ".pdf"
+}
+
+// bookmarkParts starts a new part at every top-level bookmark, named after
+// its title. Pages ahead of the first bookmark become a part of their own,
+// and bookmarks sharing a page with the next one are folded into it.
+func bookmarkParts(bookmarks []BookmarkNode, pageCount int) []splitPart {
+	var parts []splitPart
+	for i, bm := range bookmarks {
+		if bm.Page < 1 || bm.Page > pageCount {
+			continue
+		}
+		if len(parts) == 0 && bm.Page > 1 {
+			parts = append(parts, splitPart{Name: "front_matter", From: 1, To: bm.Page - 1})
+		}
+
+		to := pageCount
+		for _, next := range bookmarks[i+1:] {
+			if next.Page >= 1 && next.Page <= pageCount {
+				to = next.Page - 1
+				break
+			}
+		}
+		if to < bm.Page {
+			continue
+		}
+		parts = append(parts, splitPart{Name: safeFilename(bm.Title), From: bm.Page, To: to})
+	}
+	return parts
+}
+
+// blankSeparatedParts groups the pages between blank ones into parts. The
+// blank pages themselves are dropped, and runs of blanks count as one
+// separator.
+func blankSeparatedParts(coverage []float64, threshold float64) []splitPart {
+	var parts []splitPart
+	from := 0
+	for i, c := range coverage {
+		page := i + 1
+		if c >= threshold {
+			if from == 0 {
+				from = page
+			}
+			continue
+		}
+		if from != 0 {
+			parts = append(parts, splitPart{From: from, To: page - 1})
+			from = 0
+		}
+	}
+	if from != 0 {
+		parts = append(parts, splitPart{From: from, To: len(coverage)})
+	}
+	return parts
+}
+
+// patternParts starts a new part at every page whose text matches re,
+// named after the first capture group, or the whole match when re has no
+// groups. A page matching with the same text as the part it would follow
+// continues that part, so a header repeated on every page of a multi-page
+// invoice does not cut it up. Pages ahead of the first match become a part
+// of their own.
+func patternParts(layout []utils.TextPage, re *regexp.Regexp) []splitPart {
+	var parts []splitPart
+	for _, page := range layout {
+		lines := make([]string, len(page.Lines))
+		for i, l := range page.Lines {
+			lines[i] = l.Text()
+		}
+
+		m := re.FindStringSubmatch(strings.Join(lines, "\n"))
+		if m != nil {
+			match := m[0]
+			if len(m) > 1 && m[1] != "" {
+				match = m[1]
+			}
+			match = strings.TrimSpace(match)
+			if len(parts) == 0 || parts[len(parts)-1].Match != match {
+				name := safeFilename(match)
+				if name == "" {
+					name = "part"
+				}
+				parts = append(parts, splitPart{Name: name, Match: match, From: page.Number, To: page.Number})
+				continue
+			}
+		}
+
+		if len(parts) == 0 {
+			parts = append(parts, splitPart{Name: "front_matter", From: page.Number})
+		}
+		parts[len(parts)-1].To = page.Number
+	}
+	if len(parts) == 1 && parts[0].Match == "" {
+		return nil
+	}
+	return parts
+}
+
+// splitBySize cuts the document into parts no larger than maxBytes. Page
+// sizes measured one at a time overstate what a run of pages costs, since
+// fonts and images shared between pages are only stored once, so each part
+// starts from that estimate and is then fitted to the real output size by
+// bisection, which keeps it to a few trims per part. A single page larger
+// than maxBytes is written on its own.
+func splitBySize(inPath, outputDir string, maxBytes int64) error {
+	pageDir, err := os.MkdirTemp("", "pdfsplit-pages-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(pageDir)
+
+	if err := api.SplitFile(inPath, pageDir, 1, nil); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(pageDir)
+	if err != nil {
+		return err
+	}
+	pageCount := len(entries)
+	pageSizes := make([]int64, pageCount+1)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		// pdfcpu names single pages <name>_<page>.pdf
+		nr := strings.TrimSuffix(e.Name()[strings.LastIndex(e.Name(), "_")+1:], ".pdf")
+		page, err := strconv.Atoi(nr)
+		if err != nil || page < 1 || page > pageCount {
+			return fmt.Errorf("unexpected split output %s", e.Name())
+		}
+		pageSizes[page] = info.Size()
+	}
+
+	// probe trims pages from..to into a file of its own and returns its
+	// size, so that the probe finally chosen can be kept as the part.
+	probes := map[int]string{}
+	probe := func(from, to int) (int64, error) {
+		path, ok := probes[to]
+		if !ok {
+			path = filepath.Join(pageDir, fmt.Sprintf("probe-%d-%d.pdf", from, to))
+			if err := api.TrimFile(inPath, path, []string{fmt.Sprintf("%d-%d", from, to)}, nil); err != nil {
+				return 0, err
+			}
+			probes[to] = path
+		}
+		return fileSize(path)
+	}
+
+	for i, from := 0, 1; from <= pageCount; i++ {
+		// Single-page files each carry their own copy of shared resources,
+		// so their sizes add up to a safe first guess.
+		to := from
+		for estimate := pageSizes[from]; to < pageCount && estimate+pageSizes[to+1] <= maxBytes; {
+			to++
+			estimate += pageSizes[to]
+		}
+
+		// Find the last page that still fits by bisection between a part
+		// known to fit (fits) and one known not to (tooBig), doubling the
+		// step from the guess until a part that is too big turns up.
+		fits, tooBig := from-1, pageCount+1
+		size, err := probe(from, to)
+		if err != nil {
+			return err
+		}
+		if size <= maxBytes {
+			fits = to
+			for step := 1; fits < pageCount; step *= 2 {
+				next := min(fits+step, pageCount)
+				if size, err = probe(from, next); err != nil {
+					return err
+				}
+				if size > maxBytes {
+					tooBig = next
+					break
+				}
+				fits = next
+			}
+		} else {
+			tooBig = to
+		}
+		for tooBig-fits > 1 {
+			mid := (fits + tooBig) / 2
+			if size, err = probe(from, mid); err != nil {
+				return err
+			}
+			if size <= maxBytes {
+				fits = mid
+			} else {
+				tooBig = mid
+			}
+		}
+		// A single page larger than maxBytes becomes a part on its own.
+		to = max(fits, from)
+		if _, err := probe(from, to); err != nil {
+			return err
+		}
+
+		part := splitPart{Name: fmt.Sprintf("pages_%d-%d", from, to), From: from, To: to}
+		if err := os.Rename(probes[to], filepath.Join(outputDir, splitPartFilename(i, part))); err != nil {
+			return err
+		}
+		for _, path := range probes {
+			os.Remove(path)
+		}
+		clear(probes)
+		from = to + 1
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Lucifer7355/PDF/utils"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/color"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// layoutWord is a word of a poppler text layout together with where it
+// sits in the document.
+type layoutWord struct {
+	key  string // text used for matching, e.g. lower-cased
+	text string
+	page int
+	line int // document-wide, so words on different pages never share a line
+	box  utils.TextWord
+}
+
+// pageFrame returns the visible box and rotation of a page, which is what
+// poppler measures word positions against.
+func pageFrame(ctx *model.Context, pageNr int) (*types.Rectangle, int, error) {
+	_, _, inh, err := ctx.PageDict(pageNr, false)
+	if err != nil || inh == nil {
+		return nil, 0, fmt.Errorf("cannot read page %d", pageNr)
+	}
+	box := inh.MediaBox
+	if inh.CropBox != nil {
+		box = inh.CropBox
+	}
+	if box == nil {
+		return nil, 0, fmt.Errorf("page %d has no media box", pageNr)
+	}
+	return box, normalizeRotation(inh.Rotate), nil
+}
+
+// displayedToUser converts a rectangle measured from the top-left corner of
+// the displayed page into the page's own coordinates.
+func displayedToUser(box *types.Rectangle, rot int, x0, y0, x1, y1 float64) *types.Rectangle {
+	h := box.Height()
+	if rot == 90 || rot == 270 {
+		h = box.Width()
+	}
+	return autoCropBox([4]float64{x0, h - y1, x1, h - y0}, box, rot, 0)
+}
+
+// lineBoxes merges consecutive words sharing a line into one box per line,
+// calling fn with the page and box of each.
+func lineBoxes(words []layoutWord, fn func(page int, b utils.TextWord)) {
+	for i := 0; i < len(words); {
+		b := words[i].box
+		page, line := words[i].page, words[i].line
+		for i < len(words) && words[i].line == line {
+			w := words[i].box
+			b.XMin, b.YMin = min(b.XMin, w.XMin), min(b.YMin, w.YMin)
+			b.XMax, b.YMax = max(b.XMax, w.XMax), max(b.YMax, w.YMax)
+			i++
+		}
+		fn(page, b)
+	}
+}
+
+// markupBuilder collects text markup annotations for a document, keyed by
+// page number as pdfcpu.AddAnnotationsMap expects.
+type markupBuilder struct {
+	ctx     *model.Context
+	title   string // author shown by viewers; IDs are derived from it too
+	modDate string
+	ids     int
+	frames  map[int]*types.Rectangle
+	rots    map[int]int
+	annots  map[int][]model.AnnotationRenderer
+}
+
+func newMarkupBuilder(ctx *model.Context, title string) *markupBuilder {
+	return &markupBuilder{
+		ctx:     ctx,
+		title:   title,
+		modDate: types.DateString(time.Now()),
+		frames:  map[int]*types.Rectangle{},
+		rots:    map[int]int{},
+		annots:  map[int][]model.AnnotationRenderer{},
+	}
+}
+
+func (mb *markupBuilder) nextID() string {
+	mb.ids++
+	return mb.title + "-" + strconv.Itoa(mb.ids)
+}
+
+// rect converts a poppler box on the given page into page coordinates.
+func (mb *markupBuilder) rect(pageNr int, b utils.TextWord) (*types.Rectangle, error) {
+	box, ok := mb.frames[pageNr]
+	if !ok {
+		var rot int
+		var err error
+		if box, rot, err = pageFrame(mb.ctx, pageNr); err != nil {
+			return nil, err
+		}
+		mb.frames[pageNr], mb.rots[pageNr] = box, rot
+	}
+	return displayedToUser(box, mb.rots[pageNr], b.XMin, b.YMin, b.XMax, b.YMax), nil
+}
+
+// highlight adds one highlight annotation per page the words fall on, with
+// a quad for every line so that wrapped text is covered line by line.
+func (mb *markupBuilder) highlight(words []layoutWord, col *color.SimpleColor, contents, subject string) error {
+	quads := map[int]types.QuadPoints{}
+	rects := map[int]*types.Rectangle{}
+	var pages []int
+	var err error
+	lineBoxes(words, func(pageNr int, b utils.TextWord) {
+		if err != nil || pageNr > mb.ctx.PageCount {
+			return
+		}
+		var r *types.Rectangle
+		if r, err = mb.rect(pageNr, b); err != nil {
+			return
+		}
+		q := quads[pageNr]
+		q.AddQuadLiteral(*types.NewQuadLiteralForRect(r))
+		quads[pageNr] = q
+		if u := rects[pageNr]; u != nil {
+			r = types.NewRectangle(min(u.LL.X, r.LL.X), min(u.LL.Y, r.LL.Y), max(u.UR.X, r.UR.X), max(u.UR.Y, r.UR.Y))
+		} else {
+			pages = append(pages, pageNr)
+		}
+		rects[pageNr] = r
+	})
+	if err != nil {
+		return err
+	}
+	for _, pageNr := range pages {
+		mb.annots[pageNr] = append(mb.annots[pageNr], model.NewHighlightAnnotation(
+			*rects[pageNr], 0, contents, mb.nextID(), mb.modDate, model.AnnPrint,
+			col, 0, 0, 0, mb.title, nil, nil, "", subject, quads[pageNr]))
+	}
+	return nil
+}
+
+// caret adds a caret annotation just after the word, or just before it when
+// before is set, to mark where text is missing.
+func (mb *markupBuilder) caret(w layoutWord, before bool, col *color.SimpleColor, contents, subject string) error {
+	if w.page > mb.ctx.PageCount {
+		return nil
+	}
+	b := w.box
+	x := b.XMax
+	if before {
+		x = b.XMin
+	}
+	half := (b.YMax - b.YMin) / 4
+	r, err := mb.rect(w.page, utils.TextWord{XMin: x - half, YMin: b.YMin, XMax: x + half, YMax: b.YMax})
+	if err != nil {
+		return err
+	}
+	mb.annots[w.page] = append(mb.annots[w.page], model.NewCaretAnnotation(
+		*r, 0, contents, mb.nextID(), mb.modDate, model.AnnPrint,
+		col, 0, 0, 0, mb.title, nil, nil, "", subject, nil, false))
+	return nil
+}
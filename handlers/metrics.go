@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Lucifer7355/PDF/utils"
+)
+
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pool := utils.DefaultOfficePool()
+	if pool == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"officePool": nil,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"officePool": pool.Metrics(),
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// saveUploadedPDF stores the uploaded 'file' in a temp file named after
+// pattern, writing an error response and returning false on failure.
+func saveUploadedPDF(w http.ResponseWriter, r *http.Request, pattern string) (string, bool) {
+	return saveUploadedField(w, r, "file", pattern)
+}
+
+// saveUploadedField is saveUploadedPDF for handlers taking more than one
+// upload, each in its own form field.
+func saveUploadedField(w http.ResponseWriter, r *http.Request, field, pattern string) (string, bool) {
+	files := r.MultipartForm.File[field]
+	if len(files) == 0 {
+		jsonError(w, "Missing '"+field+"' field", http.StatusBadRequest)
+		return "", false
+	}
+	path, err := saveUploadedFile(files[0], pattern)
+	if err != nil {
+		jsonError(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return "", false
+	}
+	return path, true
+}
+
+// saveUploadedFile copies one uploaded file into a temp file named after
+// pattern and returns its path.
+func saveUploadedFile(fh *multipart.FileHeader, pattern string) (string, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, file); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// safeFilename turns free text such as a bookmark title into something
+// usable as a file name and storage key: letters, digits, '-' and '.' are
+// kept, runs of anything else become a single underscore. Leading and
+// trailing underscores and dots are dropped and the result is cut to 80
+// characters.
+func safeFilename(s string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.TrimSpace(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '.' {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	name := strings.Trim(b.String(), "_.")
+	if runes := []rune(name); len(runes) > 80 {
+		name = strings.TrimRight(string(runes[:80]), "_.")
+	}
+	return name
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/Lucifer7355/PDF/handlers"
+	"github.com/Lucifer7355/PDF/utils"
 	"github.com/joho/godotenv"
 )
 
@@ -22,8 +23,14 @@ func main() {
 		log.Println("🏭 Running in Railway — using injected system env vars")
 	}
 
+	// Start long-lived LibreOffice listeners for document conversions
+	if err := utils.StartOfficePool(); err != nil {
+		log.Println("⚠️  LibreOffice pool unavailable, conversions will spawn unoconv:", err)
+	}
+
 	// Register routes
 	http.HandleFunc("/health", handlers.HealthHandler)
+	http.HandleFunc("/metrics", handlers.MetricsHandler)
 	http.HandleFunc("/merge", handlers.MergeHandler)
 	http.HandleFunc("/compress", handlers.CompressHandler)
 	http.HandleFunc("/split", handlers.SplitHandler)
@@ -31,6 +38,26 @@ func main() {
 	http.HandleFunc("/convert-to-pdf", handlers.ConvertToPDFHandler)
 	http.HandleFunc("/reorder-pages", handlers.ReorderPagesHandler)
 	http.HandleFunc("/setMetadata", handlers.SetMetadataHandler)
+	http.HandleFunc("/html-to-pdf", handlers.HTMLToPDFHandler)
+	http.HandleFunc("/convert-from-pdf", handlers.ConvertFromPDFHandler)
+	http.HandleFunc("/linearize", handlers.LinearizeHandler)
+	http.HandleFunc("/repair", handlers.RepairHandler)
+	http.HandleFunc("/pdfa/validate", handlers.PDFAValidateHandler)
+	http.HandleFunc("/pdfa/convert", handlers.PDFAConvertHandler)
+	http.HandleFunc("/forms/fields", handlers.FormFieldsHandler)
+	http.HandleFunc("/forms/fill", handlers.FormFillHandler)
+	http.HandleFunc("/annotations", handlers.AnnotationsHandler)
+	http.HandleFunc("/redact", handlers.RedactHandler)
+	http.HandleFunc("/bookmarks", handlers.BookmarksHandler)
+	http.HandleFunc("/impose", handlers.ImposeHandler)
+	http.HandleFunc("/page-geometry", handlers.PageGeometryHandler)
+	http.HandleFunc("/overlay", handlers.OverlayHandler)
+	http.HandleFunc("/attachments", handlers.AttachmentsHandler)
+	http.HandleFunc("/compare", handlers.CompareHandler)
+	http.HandleFunc("/ocr", handlers.OCRHandler)
+	http.HandleFunc("/clean-scan", handlers.CleanScanHandler)
+	http.HandleFunc("/search", handlers.SearchHandler)
+	http.HandleFunc("/generate", handlers.GenerateHandler)
 
 	log.Println("📦 PDF Toolbox running on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
@@ -0,0 +1,412 @@
+// Package pageselect parses the page selections accepted by every handler
+// that works on a subset of pages.
+//
+// A selection is a list of terms separated by commas:
+//
+//	7          a single page
+//	3-5        a range; "5-3" selects the same pages in reverse order
+//	5-         from page 5 to the last page
+//	last       the last page
+//	-1, -2     pages counted from the end (-1 is the last page)
+//	odd, even  every odd or even page
+//	all        every page
+//	!4, !1-3   exclude pages from the rest of the selection
+//
+// Plain phrases are understood as well, so "pages 3 to 5 and page 9" and
+// "1-10 except 4" select what they say. Like "!", "except" applies only to
+// the term right after it: "1-10 except 4, 12-15" keeps 12-15. Pages are returned in the order they
+// were selected, each page at most once.
+package pageselect
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Error reports a selection that could not be parsed or that refers to
+// pages the document does not have.
+type Error struct {
+	Input string
+	Pos   int // 1-based character position, 0 when not tied to one place
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	if e.Pos > 0 {
+		return fmt.Sprintf("invalid page selection %q: %s at position %d", e.Input, e.Msg, e.Pos)
+	}
+	return fmt.Sprintf("invalid page selection %q: %s", e.Input, e.Msg)
+}
+
+// Selection is a page selection as it appears in request JSON. It accepts a
+// single string ("1-3,7"), a list of strings (["1-3", "7"]) or a list of
+// page numbers ([1, 2, 3, 7]).
+type Selection []string
+
+func (s *Selection) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		if strings.TrimSpace(str) == "" {
+			*s = nil
+		} else {
+			*s = Selection{str}
+		}
+		return nil
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("page selection must be a string or a list of pages")
+	}
+	out := make(Selection, 0, len(list))
+	for _, raw := range list {
+		var n int
+		if err := json.Unmarshal(raw, &n); err == nil {
+			out = append(out, strconv.Itoa(n))
+			continue
+		}
+		if err := json.Unmarshal(raw, &str); err != nil {
+			return fmt.Errorf("page selection entries must be page numbers or strings, got %s", raw)
+		}
+		out = append(out, str)
+	}
+	*s = out
+	return nil
+}
+
+// IsEmpty reports whether nothing was selected, which callers usually treat
+// as "every page".
+func (s Selection) IsEmpty() bool {
+	return len(s) == 0
+}
+
+// Pages resolves the selection against a document with pageCount pages.
+func (s Selection) Pages(pageCount int) ([]int, error) {
+	return Parse(strings.Join(s, ","), pageCount)
+}
+
+// PagesOrAll is like Pages but selects every page when s is empty.
+func (s Selection) PagesOrAll(pageCount int) ([]int, error) {
+	if s.IsEmpty() && pageCount > 0 {
+		return Range(1, pageCount), nil
+	}
+	return s.Pages(pageCount)
+}
+
+// Contains reports whether page is part of pages.
+func Contains(pages []int, page int) bool {
+	for _, p := range pages {
+		if p == page {
+			return true
+		}
+	}
+	return false
+}
+
+// Range returns the pages from..to inclusive, counting down when from > to.
+func Range(from, to int) []int {
+	step := 1
+	if from > to {
+		step = -1
+	}
+	pages := make([]int, 0, (to-from)*step+1)
+	for p := from; ; p += step {
+		pages = append(pages, p)
+		if p == to {
+			break
+		}
+	}
+	return pages
+}
+
+// Format writes pages as a compact selection in pdfcpu's syntax, joining
+// ascending runs into ranges: [1 2 3 7] becomes ["1-3", "7"].
+func Format(pages []int) []string {
+	var out []string
+	for i := 0; i < len(pages); {
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 {
+			j++
+		}
+		if j == i {
+			out = append(out, strconv.Itoa(pages[i]))
+		} else {
+			out = append(out, fmt.Sprintf("%d-%d", pages[i], pages[j]))
+		}
+		i = j + 1
+	}
+	return out
+}
+
+type tokenKind int
+
+const (
+	tokNumber tokenKind = iota
+	tokDash
+	tokComma
+	tokNot
+	tokExcept
+	tokKeyword
+	tokEnd
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// words maps the words allowed in plain phrases to the token they stand
+// for. Filler words map to nothing and are skipped.
+var words = map[string]tokenKind{
+	"to":        tokDash,
+	"through":   tokDash,
+	"thru":      tokDash,
+	"until":     tokDash,
+	"and":       tokComma,
+	"except":    tokExcept,
+	"excluding": tokExcept,
+	"without":   tokExcept,
+	"but":       tokExcept,
+	"not":       tokExcept,
+	"last":      tokKeyword,
+	"first":     tokKeyword,
+	"odd":       tokKeyword,
+	"even":      tokKeyword,
+	"all":       tokKeyword,
+}
+
+var fillers = map[string]bool{
+	"page": true, "pages": true, "p": true, "pp": true,
+	"from": true, "the": true, "of": true, "only": true,
+}
+
+func tokenize(input string) ([]token, error) {
+	var toks []token
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' || r == '–' || r == '—':
+			toks = append(toks, token{tokDash, "-", i + 1})
+			i++
+		case r == ',' || r == ';':
+			toks = append(toks, token{tokComma, ",", i + 1})
+			i++
+		case r == '!':
+			toks = append(toks, token{tokNot, "!", i + 1})
+			i++
+		case r == '*':
+			toks = append(toks, token{tokKeyword, "all", i + 1})
+			i++
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			toks = append(toks, token{tokNumber, string(runes[i:j]), i + 1})
+			i = j
+		case unicode.IsLetter(r):
+			j := i
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			word := strings.ToLower(string(runes[i:j]))
+			kind, ok := words[word]
+			switch {
+			case ok:
+				toks = append(toks, token{kind, word, i + 1})
+			case !fillers[word]:
+				return nil, &Error{Input: input, Pos: i + 1, Msg: fmt.Sprintf("unknown word %q", word)}
+			}
+			i = j
+		default:
+			return nil, &Error{Input: input, Pos: i + 1, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+	toks = append(toks, token{tokEnd, "", len(runes) + 1})
+	return toks, nil
+}
+
+type parser struct {
+	input     string
+	pageCount int
+	toks      []token
+	i         int
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEnd {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &Error{Input: p.input, Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(t token) string {
+	if t.kind == tokEnd {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// endpoint parses a page reference: a number, a negative number counted
+// from the end, "first" or "last".
+func (p *parser) endpoint() (int, error) {
+	t := p.next()
+	negative := false
+	if t.kind == tokDash {
+		negative = true
+		t = p.next()
+		if t.kind != tokNumber {
+			return 0, p.errorf(t, "expected a page number after \"-\", got %s", describe(t))
+		}
+	}
+
+	switch {
+	case t.kind == tokKeyword && t.text == "last":
+		return p.pageCount, nil
+	case t.kind == tokKeyword && t.text == "first":
+		return 1, nil
+	case t.kind != tokNumber:
+		return 0, p.errorf(t, "expected a page number, got %s", describe(t))
+	}
+
+	n, err := strconv.Atoi(t.text)
+	if err != nil {
+		return 0, p.errorf(t, "page number %s is too large", t.text)
+	}
+	if negative {
+		if n == 0 || n > p.pageCount {
+			return 0, p.errorf(t, "-%d is outside the document (%d pages)", n, p.pageCount)
+		}
+		return p.pageCount + 1 - n, nil
+	}
+	if n == 0 {
+		return 0, p.errorf(t, "page 0 does not exist; pages are numbered from 1")
+	}
+	if n > p.pageCount {
+		return 0, p.errorf(t, "page %d is out of range (document has %d pages)", n, p.pageCount)
+	}
+	return n, nil
+}
+
+// term parses one selection term and returns its pages.
+func (p *parser) term() ([]int, error) {
+	t := p.peek()
+	if t.kind == tokKeyword && t.text != "last" && t.text != "first" {
+		p.next()
+		var pages []int
+		for n := 1; n <= p.pageCount; n++ {
+			if t.text == "all" || (t.text == "odd") == (n%2 == 1) {
+				pages = append(pages, n)
+			}
+		}
+		return pages, nil
+	}
+
+	from, err := p.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokDash {
+		return []int{from}, nil
+	}
+	p.next()
+
+	switch p.peek().kind {
+	case tokComma, tokNot, tokExcept, tokEnd:
+		return Range(from, p.pageCount), nil
+	}
+	to, err := p.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tokDash {
+		return nil, p.errorf(p.peek(), "a range has only two ends")
+	}
+	return Range(from, to), nil
+}
+
+// Parse resolves a selection against a document with pageCount pages.
+func Parse(input string, pageCount int) ([]int, error) {
+	if pageCount < 1 {
+		return nil, &Error{Input: input, Msg: "the document has no pages"}
+	}
+	toks, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, pageCount: pageCount, toks: toks}
+
+	if len(toks) == 1 {
+		return nil, &Error{Input: input, Msg: "selection is empty"}
+	}
+
+	var included []int
+	excluded := map[int]bool{}
+	seen := map[int]bool{}
+	hasInclude := false
+
+	for {
+		// "!" and "except" exclude the one term that follows them.
+		exclude := false
+		if t := p.peek(); t.kind == tokNot || t.kind == tokExcept {
+			p.next()
+			exclude = true
+		}
+		pages, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, n := range pages {
+			if exclude {
+				excluded[n] = true
+			} else if !seen[n] {
+				seen[n] = true
+				included = append(included, n)
+			}
+		}
+		if !exclude {
+			hasInclude = true
+		}
+
+		switch t := p.peek(); t.kind {
+		case tokEnd:
+		case tokComma:
+			p.next()
+			continue
+		case tokExcept:
+			continue
+		default:
+			return nil, p.errorf(t, "expected \",\" before %s", describe(t))
+		}
+		break
+	}
+
+	if !hasInclude {
+		included = Range(1, pageCount)
+	}
+	var pages []int
+	for _, n := range included {
+		if !excluded[n] {
+			pages = append(pages, n)
+		}
+	}
+	if len(pages) == 0 {
+		return nil, &Error{Input: input, Msg: "no pages are left after exclusions"}
+	}
+	return pages, nil
+}
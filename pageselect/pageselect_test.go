@@ -0,0 +1,168 @@
+package pageselect
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []int
+	}{
+		{"7", []int{7}},
+		{"3-5", []int{3, 4, 5}},
+		{"5-3", []int{5, 4, 3}},
+		{"8-", []int{8, 9, 10}},
+		{"last", []int{10}},
+		{"first", []int{1}},
+		{"-1", []int{10}},
+		{"-2", []int{9}},
+		{"-3--1", []int{8, 9, 10}},
+		{"odd", []int{1, 3, 5, 7, 9}},
+		{"even", []int{2, 4, 6, 8, 10}},
+		{"all", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"*", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"!4", []int{1, 2, 3, 5, 6, 7, 8, 9, 10}},
+		{"1-5, !1-3", []int{4, 5}},
+		{"1-3,7", []int{1, 2, 3, 7}},
+		{"1-3; 7", []int{1, 2, 3, 7}},
+		{"3,1,3,2", []int{3, 1, 2}},
+		{"1–3", []int{1, 2, 3}},
+		{"pages 3 to 5 and page 9", []int{3, 4, 5, 9}},
+		{"page 2 through last", []int{2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"1-10 except 4", []int{1, 2, 3, 5, 6, 7, 8, 9, 10}},
+		{"1-6 except 4 except 2", []int{1, 3, 5, 6}},
+		{"1-6 except 4, 9-10", []int{1, 2, 3, 5, 6, 9, 10}},
+		{"except 2-9", []int{1, 10}},
+		{"odd excluding 1", []int{3, 5, 7, 9}},
+		{"all but last", []int{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.input, 10)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Parse(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"0", 1},
+		{"11", 1},
+		{"-11", 2},
+		{"3-12", 3},
+		{"1-2-3", 4},
+		{"1,,2", 3},
+		{"1,", 3},
+		{",1", 1},
+		{"3 4", 3},
+		{"1-5 !3", 5},
+		{"1-10 except", 12},
+		{"except", 7},
+		{"2 x", 3},
+		{"1-3 4", 5},
+		{"1#", 2},
+		{"!1-10", 0},
+		{"99999999999999999999", 1},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.input, 10)
+		var perr *Error
+		if !errors.As(err, &perr) {
+			t.Errorf("Parse(%q): want *Error, got %v", tt.input, err)
+			continue
+		}
+		if perr.Pos != tt.pos {
+			t.Errorf("Parse(%q): error at position %d, want %d (%v)", tt.input, perr.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestSelection(t *testing.T) {
+	tests := []struct {
+		json string
+		want []int
+	}{
+		{`"1-3,7"`, []int{1, 2, 3, 7}},
+		{`["1-3", "7"]`, []int{1, 2, 3, 7}},
+		{`[1, 2, 3, 7]`, []int{1, 2, 3, 7}},
+		{`""`, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{`[]`, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+	}
+	for _, tt := range tests {
+		var s Selection
+		if err := json.Unmarshal([]byte(tt.json), &s); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.json, err)
+			continue
+		}
+		got, err := s.PagesOrAll(10)
+		if err != nil {
+			t.Errorf("%s: %v", tt.json, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.json, got, tt.want)
+		}
+	}
+
+	var s Selection
+	if err := json.Unmarshal([]byte(`{"pages": 1}`), &s); err == nil {
+		t.Error("unmarshal of an object: want error")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	got := Format([]int{1, 2, 3, 7, 9, 10, 5})
+	want := []string{"1-3", "7", "9-10", "5"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Format = %v, want %v", got, want)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"7", "3-5", "5-3", "5-", "last", "-1", "odd", "even", "all", "!4",
+		"1-3,7", "pages 3 to 5 and page 9", "1-10 except 4, 12-15", ",1", "3 4",
+	} {
+		f.Add(seed, 20)
+	}
+	f.Fuzz(func(t *testing.T, input string, pageCount int) {
+		pageCount = pageCount%500 + 1
+		if pageCount < 1 {
+			pageCount += 500
+		}
+		pages, err := Parse(input, pageCount)
+		if err != nil {
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("Parse(%q, %d): error is %T, want *Error", input, pageCount, err)
+			}
+			return
+		}
+		if len(pages) == 0 {
+			t.Fatalf("Parse(%q, %d): no pages and no error", input, pageCount)
+		}
+		seen := map[int]bool{}
+		for _, p := range pages {
+			if p < 1 || p > pageCount {
+				t.Fatalf("Parse(%q, %d): page %d out of range", input, pageCount, p)
+			}
+			if seen[p] {
+				t.Fatalf("Parse(%q, %d): page %d selected twice", input, pageCount, p)
+			}
+			seen[p] = true
+		}
+	})
+}
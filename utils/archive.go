@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ZipFiles writes the given files into a flat ZIP archive at zipPath, using
+// each file's base name as the entry name.
+func ZipFiles(zipPath string, files []string) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			zw.Close()
+			return err
+		}
+
+		entry, err := zw.Create(filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(entry, f)
+		}
+		f.Close()
+		if err != nil {
+			zw.Close()
+			return err
+		}
+	}
+	return zw.Close()
+}
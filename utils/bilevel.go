@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"image"
+	"image/draw"
+)
+
+// darkLevel is the gray value below which Despeckle treats a pixel as ink.
+const darkLevel = 128
+
+func grayOf(img *image.RGBA, i int) int {
+	return (299*int(img.Pix[i]) + 587*int(img.Pix[i+1]) + 114*int(img.Pix[i+2])) / 1000
+}
+
+// Despeckle whitens every patch of connected dark pixels no larger than
+// maxArea pixels, the dust and noise scanners leave on a page, and returns
+// how many patches it removed. Patches are 8-connected so that thin
+// diagonal strokes count as one.
+func Despeckle(img *image.RGBA, maxArea int) int {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dark := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dark[y*w+x] = grayOf(img, img.PixOffset(b.Min.X+x, b.Min.Y+y)) < darkLevel
+		}
+	}
+
+	removed := 0
+	seen := make([]bool, w*h)
+	var patch, stack []int
+	for start := range dark {
+		if !dark[start] || seen[start] {
+			continue
+		}
+		patch, stack = patch[:0], append(stack[:0], start)
+		seen[start] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			// Large patches are text or artwork; stop collecting them but
+			// keep marking them seen.
+			if len(patch) <= maxArea {
+				patch = append(patch, i)
+			}
+			x, y := i%w, i/w
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+					if j := ny*w + nx; dark[j] && !seen[j] {
+						seen[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+		if len(patch) > maxArea {
+			continue
+		}
+		removed++
+		for _, i := range patch {
+			o := img.PixOffset(b.Min.X+i%w, b.Min.Y+i/w)
+			img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = 255, 255, 255, 255
+		}
+	}
+	return removed
+}
+
+// Binarize turns img into pure black and white, choosing the cut-off with
+// Otsu's method so that faint and dark scans both come out clean.
+func Binarize(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, b.Min, draw.Src)
+
+	var hist [256]int
+	for _, v := range gray.Pix {
+		hist[v]++
+	}
+	total := len(gray.Pix)
+	sum := 0
+	for v, n := range hist {
+		sum += v * n
+	}
+
+	// Otsu: pick the threshold maximising the variance between the two
+	// classes of pixels it separates.
+	threshold, best := 128, -1.0
+	sumBelow, below := 0, 0
+	for t := 0; t < 256; t++ {
+		below += hist[t]
+		if below == 0 {
+			continue
+		}
+		above := total - below
+		if above == 0 {
+			break
+		}
+		sumBelow += t * hist[t]
+		meanBelow := float64(sumBelow) / float64(below)
+		meanAbove := float64(sum-sumBelow) / float64(above)
+		if v := float64(below) * float64(above) * (meanBelow - meanAbove) * (meanBelow - meanAbove); v > best {
+			threshold, best = t, v
+		}
+	}
+
+	for i, v := range gray.Pix {
+		if int(v) <= threshold {
+			gray.Pix[i] = 0
+		} else {
+			gray.Pix[i] = 255
+		}
+	}
+	return gray
+}
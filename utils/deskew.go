@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// skewSampleWidth is roughly how many pixels across a page SkewAngle looks
+// at; larger scans are sampled on a coarser grid.
+const skewSampleWidth = 1000
+
+// SkewAngle estimates by how many degrees the lines of text in a scanned
+// page slope downwards to the right, searching up to maxDegrees either
+// way. It picks the angle whose horizontal projection of the dark pixels
+// has the sharpest peaks, which is when rows of text line up with rows of
+// pixels. Pages with too little ink to tell report 0.
+func SkewAngle(img image.Image, maxDegrees float64) float64 {
+	b := img.Bounds()
+	step := max(1, b.Dx()/skewSampleWidth)
+
+	var xs, ys []float64
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 128 {
+				xs = append(xs, float64(x-b.Min.X))
+				ys = append(ys, float64(y-b.Min.Y))
+			}
+		}
+	}
+	if len(xs) < 100 {
+		return 0
+	}
+
+	// Rotated rows fall between -width and height+width, so one bin per
+	// sampled row over that span covers every angle.
+	offset := b.Dx()/step + 1
+	bins := make([]int, 2*offset+b.Dy()/step+1)
+	score := func(deg float64) float64 {
+		clear(bins)
+		sin, cos := math.Sincos(deg * math.Pi / 180)
+		for i := range xs {
+			bins[offset+int(math.Floor((ys[i]*cos-xs[i]*sin)/float64(step)))]++
+		}
+		var s float64
+		for _, n := range bins {
+			s += float64(n) * float64(n)
+		}
+		return s
+	}
+	search := func(from, to, by float64) float64 {
+		best, bestScore := 0.0, -1.0
+		steps := int(math.Round((to - from) / by))
+		for i := 0; i <= steps; i++ {
+			deg := from + float64(i)*by
+			s := score(deg)
+			if s > bestScore || (s == bestScore && math.Abs(deg) < math.Abs(best)) {
+				best, bestScore = deg, s
+			}
+		}
+		return best
+	}
+
+	coarse := search(-maxDegrees, maxDegrees, 0.5)
+	return search(coarse-0.5, coarse+0.5, 0.05)
+}
+
+// RotateImage turns img counter-clockwise by degrees around its centre,
+// keeping the original size and filling uncovered corners with white.
+// Pixels are sampled bilinearly.
+func RotateImage(img image.Image, degrees float64) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	out := image.NewRGBA(src.Bounds())
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	cx, cy := float64(w)/2, float64(h)/2
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			sx := dx*cos - dy*sin + cx - 0.5
+			sy := dx*sin + dy*cos + cy - 0.5
+
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			fx, fy := sx-float64(x0), sy-float64(y0)
+			o := out.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				px := func(x, y int) float64 {
+					if x < 0 || y < 0 || x >= w || y >= h {
+						return 255
+					}
+					return float64(src.Pix[src.PixOffset(x, y)+c])
+				}
+				v := px(x0, y0)*(1-fx)*(1-fy) + px(x0+1, y0)*fx*(1-fy) +
+					px(x0, y0+1)*(1-fx)*fy + px(x0+1, y0+1)*fx*fy
+				out.Pix[o+c] = uint8(math.Round(v))
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ghostscriptTimeout bounds a single ghostscript run when the caller's
+// context has no deadline of its own.
+const ghostscriptTimeout = 5 * time.Minute
+
+// RunGhostscript runs gs in batch mode with -dSAFER, so documents cannot
+// read or write files beyond the ones named in args.
+func RunGhostscript(ctx context.Context, args ...string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ghostscriptTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	cmdArgs := append([]string{"-dSAFER", "-dBATCH", "-dNOPAUSE", "-dQUIET"}, args...)
+
+	out, err := exec.CommandContext(ctx, "gs", cmdArgs...).CombinedOutput()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		fmt.Println("[RunGhostscript] ❌ gs failed:", err)
+		return out, err
+	}
+
+	fmt.Println("[RunGhostscript] ✅ gs finished in", time.Since(start))
+	return out, nil
+}
+
+// InkCoverage returns, for every page of path, the fraction of the page
+// covered by ink summed over the C, M, Y and K channels as reported by the
+// inkcov device. A blank page scores 0; a page of solid black scores 1.
+func InkCoverage(ctx context.Context, path string) ([]float64, error) {
+	out, err := RunGhostscript(ctx, "-sDEVICE=inkcov", "-r72", "-o", "-", "-f", path)
+	if err != nil {
+		return nil, fmt.Errorf("ghostscript failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	var coverage []float64
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[4] != "CMYK" {
+			continue
+		}
+		var sum float64
+		for _, f := range fields[:4] {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected inkcov output: %q", line)
+			}
+			sum += v
+		}
+		coverage = append(coverage, sum)
+	}
+	return coverage, nil
+}
+
+// BoundingBoxes returns, for every page of path, the box around everything
+// that would be painted, as [llx lly urx ury] in points relative to the
+// lower-left corner of the page as displayed. Empty pages get a zero box.
+func BoundingBoxes(ctx context.Context, path string) ([][4]float64, error) {
+	out, err := RunGhostscript(ctx, "-sDEVICE=bbox", "-f", path)
+	if err != nil {
+		return nil, fmt.Errorf("ghostscript failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	var boxes [][4]float64
+	for _, line := range strings.Split(string(out), "\n") {
+		rest, ok := strings.CutPrefix(strings.TrimSpace(line), "%%HiResBoundingBox:")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected bbox output: %q", line)
+		}
+		var box [4]float64
+		for i, f := range fields {
+			if box[i], err = strconv.ParseFloat(f, 64); err != nil {
+				return nil, fmt.Errorf("unexpected bbox output: %q", line)
+			}
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, nil
+}
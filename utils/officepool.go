@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// OfficePool keeps a fixed number of headless LibreOffice listeners running so
+// conversions only pay for the document itself, not for starting soffice.
+// unoconv is used as the client and talks to a worker over its UNO socket.
+type OfficePool struct {
+	workers        []*officeWorker
+	idle           chan *officeWorker
+	timeout        time.Duration
+	healthInterval time.Duration
+	stop           chan struct{}
+
+	mu      sync.Mutex
+	metrics OfficePoolMetrics
+}
+
+// OfficePoolMetrics is a snapshot of the pool counters, exposed on /metrics.
+type OfficePoolMetrics struct {
+	Workers          int     `json:"workers"`
+	IdleWorkers      int     `json:"idleWorkers"`
+	Conversions      int64   `json:"conversions"`
+	Failures         int64   `json:"failures"`
+	Timeouts         int64   `json:"timeouts"`
+	Restarts         int64   `json:"restarts"`
+	UnhealthyWorkers int     `json:"unhealthyWorkers"` // failed to restart, retried by the health loop
+	QueueWaitTotalMs float64 `json:"queueWaitTotalMs"`
+	QueueWaitMaxMs   float64 `json:"queueWaitMaxMs"`
+	ConvertTotalMs   float64 `json:"convertTotalMs"`
+	ConvertMaxMs     float64 `json:"convertMaxMs"`
+}
+
+type officeWorker struct {
+	id         int
+	port       int
+	profileDir string
+
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+var defaultOfficePool *OfficePool
+
+// StartOfficePool launches the shared LibreOffice pool. Size, base port and
+// the per-conversion timeout come from OFFICE_POOL_SIZE, OFFICE_BASE_PORT and
+// OFFICE_CONVERT_TIMEOUT (a Go duration such as "90s").
+func StartOfficePool() error {
+	size := envInt("OFFICE_POOL_SIZE", 2)
+	basePort := envInt("OFFICE_BASE_PORT", 2002)
+
+	timeout := 2 * time.Minute
+	if v := os.Getenv("OFFICE_CONVERT_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid OFFICE_CONVERT_TIMEOUT: %w", err)
+		}
+		timeout = d
+	}
+
+	pool, err := NewOfficePool(size, basePort, timeout)
+	if err != nil {
+		return err
+	}
+	defaultOfficePool = pool
+	return nil
+}
+
+// DefaultOfficePool returns the shared pool, or nil if it was not started.
+func DefaultOfficePool() *OfficePool {
+	return defaultOfficePool
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// NewOfficePool starts size soffice listeners on consecutive ports beginning
+// at basePort and begins health-checking them.
+func NewOfficePool(size, basePort int, timeout time.Duration) (*OfficePool, error) {
+	start := time.Now()
+	fmt.Printf("[OfficePool] ➜ Starting %d LibreOffice workers...\n", size)
+
+	p := &OfficePool{
+		idle:           make(chan *officeWorker, size),
+		timeout:        timeout,
+		healthInterval: 30 * time.Second,
+		stop:           make(chan struct{}),
+	}
+
+	for i := 0; i < size; i++ {
+		w := &officeWorker{
+			id:         i + 1,
+			port:       basePort + i,
+			profileDir: filepath.Join(os.TempDir(), fmt.Sprintf("lo-worker-%d", i+1)),
+		}
+		if err := w.start(); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("worker %d: %w", w.id, err)
+		}
+		p.workers = append(p.workers, w)
+		p.idle <- w
+	}
+	p.metrics.Workers = size
+
+	go p.healthLoop()
+
+	fmt.Println("[OfficePool] ✅ Workers ready in", time.Since(start))
+	return p, nil
+}
+
+// Convert runs unoconv against an idle worker. The context bounds both the
+// wait for a worker and the conversion itself; if no deadline is set the
+// pool's default timeout applies. A worker whose conversion was cut short,
+// by a timeout or by the client going away, or whose process has died is
+// restarted before it is returned to the pool, since soffice may still be
+// busy with the abandoned document.
+func (p *OfficePool) Convert(ctx context.Context, inputPath, outputPath, format string, args ...string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	queued := time.Now()
+	var w *officeWorker
+	select {
+	case w = <-p.idle:
+	case <-ctx.Done():
+		p.record(time.Since(queued), 0, ctx.Err())
+		return nil, fmt.Errorf("waiting for a LibreOffice worker: %w", ctx.Err())
+	}
+	wait := time.Since(queued)
+
+	began := time.Now()
+	cmdArgs := []string{
+		"--connection", w.connection(),
+		"--no-launch",
+		"-f", format,
+		"-o", outputPath,
+	}
+	cmdArgs = append(cmdArgs, args...)
+	cmdArgs = append(cmdArgs, inputPath)
+
+	out, err := exec.CommandContext(ctx, "unoconv", cmdArgs...).CombinedOutput()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	p.record(wait, time.Since(began), err)
+
+	if ctx.Err() != nil || !w.alive() {
+		p.recover(w)
+	} else {
+		p.idle <- w
+	}
+
+	return out, err
+}
+
+// Metrics returns a snapshot of the pool counters.
+func (p *OfficePool) Metrics() OfficePoolMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := p.metrics
+	m.IdleWorkers = len(p.idle)
+	return m
+}
+
+// Close stops health checks and terminates all workers.
+func (p *OfficePool) Close() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	for _, w := range p.workers {
+		w.kill()
+	}
+}
+
+func (p *OfficePool) record(wait, convert time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	waitMs := float64(wait) / float64(time.Millisecond)
+	convertMs := float64(convert) / float64(time.Millisecond)
+
+	p.metrics.Conversions++
+	p.metrics.QueueWaitTotalMs += waitMs
+	if waitMs > p.metrics.QueueWaitMaxMs {
+		p.metrics.QueueWaitMaxMs = waitMs
+	}
+	p.metrics.ConvertTotalMs += convertMs
+	if convertMs > p.metrics.ConvertMaxMs {
+		p.metrics.ConvertMaxMs = convertMs
+	}
+	if err != nil {
+		p.metrics.Failures++
+		if errors.Is(err, context.DeadlineExceeded) {
+			p.metrics.Timeouts++
+		}
+	}
+}
+
+func (p *OfficePool) restart(w *officeWorker) error {
+	fmt.Printf("[OfficePool] ⚠️  Restarting worker %d (port %d)\n", w.id, w.port)
+	w.kill()
+	err := w.start()
+	if err != nil {
+		fmt.Printf("[OfficePool] ❌ Worker %d failed to restart: %v\n", w.id, err)
+	}
+
+	p.mu.Lock()
+	p.metrics.Restarts++
+	p.mu.Unlock()
+	return err
+}
+
+// recover restarts w and returns it to the pool. A worker that fails to
+// restart is kept out of the pool and retried every health interval, so
+// callers are never handed a dead worker.
+func (p *OfficePool) recover(w *officeWorker) {
+	if p.restart(w) == nil {
+		p.idle <- w
+		return
+	}
+
+	p.mu.Lock()
+	p.metrics.UnhealthyWorkers++
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(p.healthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+			if p.restart(w) == nil {
+				p.mu.Lock()
+				p.metrics.UnhealthyWorkers--
+				p.mu.Unlock()
+				p.idle <- w
+				return
+			}
+		}
+	}()
+}
+
+// healthLoop periodically checks idle workers and restarts any whose process
+// has exited or whose socket no longer accepts connections. Busy workers are
+// covered by the conversion timeout instead.
+func (p *OfficePool) healthLoop() {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for i := 0; i < len(p.workers); i++ {
+			var w *officeWorker
+			select {
+			case w = <-p.idle:
+			default:
+				continue
+			}
+			if w.healthy() {
+				p.idle <- w
+			} else {
+				p.recover(w)
+			}
+		}
+	}
+}
+
+func (w *officeWorker) connection() string {
+	return fmt.Sprintf("socket,host=127.0.0.1,port=%d;urp;StarOffice.ComponentContext", w.port)
+}
+
+func (w *officeWorker) start() error {
+	cmd := exec.Command("soffice",
+		"--headless", "--invisible", "--nologo", "--nodefault",
+		"--norestore", "--nolockcheck", "--nofirststartwizard",
+		"-env:UserInstallation=file://"+w.profileDir,
+		"--accept="+w.connection(),
+	)
+	// Own process group so kill() also takes down soffice.bin.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	w.cmd = cmd
+	w.exited = exited
+
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if w.healthy() {
+			fmt.Printf("[OfficePool] ✅ Worker %d listening on port %d\n", w.id, w.port)
+			return nil
+		}
+		if !w.alive() {
+			return fmt.Errorf("soffice exited during startup")
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	w.kill()
+	return fmt.Errorf("soffice did not accept connections on port %d", w.port)
+}
+
+func (w *officeWorker) alive() bool {
+	if w.exited == nil {
+		return false
+	}
+	select {
+	case <-w.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (w *officeWorker) healthy() bool {
+	if !w.alive() {
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", w.port), 2*time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (w *officeWorker) kill() {
+	if w.cmd == nil || w.cmd.Process == nil {
+		return
+	}
+	syscall.Kill(-w.cmd.Process.Pid, syscall.SIGKILL)
+	select {
+	case <-w.exited:
+	case <-time.After(5 * time.Second):
+	}
+}
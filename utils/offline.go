@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// unreachableProxy is handed to LibreOffice as its proxy when it cannot be
+// put in its own network namespace, so any request it makes fails fast.
+const unreachableProxy = "http://127.0.0.1:9"
+
+var (
+	netnsOnce      sync.Once
+	netnsAvailable bool
+)
+
+// canIsolateNetwork reports whether processes can be started in a network
+// namespace of their own, which needs user namespaces or CAP_SYS_ADMIN.
+func canIsolateNetwork() bool {
+	netnsOnce.Do(func() {
+		netnsAvailable = exec.Command("unshare", "--net", "--map-root-user", "true").Run() == nil
+		if !netnsAvailable {
+			fmt.Println("[ConvertOffline] ⚠️  Network namespaces unavailable, falling back to an unreachable proxy")
+		}
+	})
+	return netnsAvailable
+}
+
+// ConvertOffline converts inputPath with a one-off LibreOffice that has no
+// network access, writing the result to outputPath. convertTo is passed to
+// soffice --convert-to (e.g. "pdf:writer_pdf_Export") and extra arguments
+// such as --infilter go before the input file.
+//
+// It is meant for untrusted documents that may reference remote resources.
+// The pool workers are shared and networked, so they are not used.
+func ConvertOffline(ctx context.Context, inputPath, outputPath, convertTo string, args ...string) ([]byte, error) {
+	start := time.Now()
+	fmt.Printf("[ConvertOffline] ➜ Converting %s to %s\n", inputPath, convertTo)
+
+	ctx, cancel := context.WithTimeout(ctx, standaloneTimeout)
+	defer cancel()
+
+	profileDir, err := os.MkdirTemp("", "lo-offline-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(profileDir)
+	outDir := filepath.Join(profileDir, "out")
+
+	cmdArgs := []string{
+		"--headless", "--invisible", "--nologo", "--nodefault",
+		"--norestore", "--nolockcheck", "--nofirststartwizard",
+		"-env:UserInstallation=file://" + filepath.Join(profileDir, "profile"),
+		"--convert-to", convertTo,
+		"--outdir", outDir,
+	}
+	cmdArgs = append(cmdArgs, args...)
+	cmdArgs = append(cmdArgs, inputPath)
+
+	var cmd *exec.Cmd
+	if canIsolateNetwork() {
+		cmd = exec.CommandContext(ctx, "unshare", append([]string{"--net", "--map-root-user", "soffice"}, cmdArgs...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, "soffice", cmdArgs...)
+	}
+	cmd.Env = append(os.Environ(),
+		"http_proxy="+unreachableProxy, "https_proxy="+unreachableProxy, "ftp_proxy="+unreachableProxy,
+		"HTTP_PROXY="+unreachableProxy, "HTTPS_PROXY="+unreachableProxy, "FTP_PROXY="+unreachableProxy,
+		"no_proxy=", "NO_PROXY=",
+	)
+	// Own process group so a cancelled conversion also takes down soffice.bin.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		fmt.Println("[ConvertOffline] ❌ Conversion failed:", err)
+		return out, err
+	}
+
+	ext, _, _ := strings.Cut(convertTo, ":")
+	base := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	if err := os.Rename(filepath.Join(outDir, base+"."+ext), outputPath); err != nil {
+		return out, fmt.Errorf("LibreOffice produced no output: %w", err)
+	}
+
+	fmt.Println("[ConvertOffline] ✅ Conversion done in", time.Since(start))
+	return out, nil
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// popplerTimeout bounds a single poppler-utils run when the caller's
+// context has no deadline of its own.
+const popplerTimeout = 5 * time.Minute
+
+// TextWord is a word on a page with its bounding box in points, measured
+// from the top-left corner of the page as displayed (crop box, rotation
+// applied).
+type TextWord struct {
+	Text string  `json:"text"`
+	XMin float64 `json:"xMin"`
+	YMin float64 `json:"yMin"`
+	XMax float64 `json:"xMax"`
+	YMax float64 `json:"yMax"`
+}
+
+// TextLine is a run of words poppler grouped onto one line. Lines sharing
+// a Block belong to the same block of text, usually a paragraph.
+type TextLine struct {
+	Block int
+	Words []TextWord
+}
+
+// Text joins the words of the line with single spaces.
+func (l TextLine) Text() string {
+	parts := make([]string, len(l.Words))
+	for i, w := range l.Words {
+		parts[i] = w.Text
+	}
+	return strings.Join(parts, " ")
+}
+
+// TextPage is the word layout of a single page.
+type TextPage struct {
+	Number int
+	Width  float64
+	Height float64
+	Lines  []TextLine
+}
+
+// ExtractTextLayout runs pdftotext -bbox-layout on path and returns the
+// word boxes of every page.
+func ExtractTextLayout(ctx context.Context, path string) ([]TextPage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, popplerTimeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pdftotext", "-bbox-layout", "-enc", "UTF-8", path, "-")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("pdftotext failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseTextLayout(&stdout)
+}
+
+// parseTextLayout reads the XHTML written by pdftotext -bbox-layout. Flows
+// are flattened; blocks are only kept as a number on each line.
+func parseTextLayout(r io.Reader) ([]TextPage, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var pages []TextPage
+	block := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid pdftotext output: %v", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch se.Name.Local {
+		case "page":
+			pages = append(pages, TextPage{
+				Number: len(pages) + 1,
+				Width:  xmlFloatAttr(se, "width"),
+				Height: xmlFloatAttr(se, "height"),
+			})
+		case "block":
+			block++
+		case "line":
+			if len(pages) > 0 {
+				p := &pages[len(pages)-1]
+				p.Lines = append(p.Lines, TextLine{Block: block})
+			}
+		case "word":
+			var text string
+			if err := dec.DecodeElement(&text, &se); err != nil {
+				return nil, fmt.Errorf("invalid pdftotext output: %v", err)
+			}
+			if len(pages) == 0 {
+				continue
+			}
+			p := &pages[len(pages)-1]
+			if len(p.Lines) == 0 {
+				p.Lines = append(p.Lines, TextLine{Block: block})
+			}
+			l := &p.Lines[len(p.Lines)-1]
+			l.Words = append(l.Words, TextWord{
+				Text: text,
+				XMin: xmlFloatAttr(se, "xMin"),
+				YMin: xmlFloatAttr(se, "yMin"),
+				XMax: xmlFloatAttr(se, "xMax"),
+				YMax: xmlFloatAttr(se, "yMax"),
+			})
+		}
+	}
+	return pages, nil
+}
+
+func xmlFloatAttr(se xml.StartElement, name string) float64 {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			f, _ := strconv.ParseFloat(a.Value, 64)
+			return f
+		}
+	}
+	return 0
+}
+
+// RenderPage rasterizes a single page of path to a PNG at the given
+// resolution and returns the path of the image, which is written next to
+// prefix.
+func RenderPage(ctx context.Context, path string, page, dpi int, prefix string) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, popplerTimeout)
+		defer cancel()
+	}
+
+	n := strconv.Itoa(page)
+	out, err := exec.CommandContext(ctx, "pdftoppm",
+		"-png", "-r", strconv.Itoa(dpi),
+		"-f", n, "-l", n, "-singlefile",
+		path, prefix,
+	).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("pdftoppm failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return prefix + ".png", nil
+}
+
+// RunPoppler runs one of the poppler-utils tools, such as pdftotext or
+// pdftoppm, and returns its combined output.
+func RunPoppler(ctx context.Context, tool string, args ...string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, popplerTimeout)
+		defer cancel()
+	}
+
+	out, err := exec.CommandContext(ctx, tool, args...).CombinedOutput()
+	if err != nil && ctx.Err() != nil {
+		return out, ctx.Err()
+	}
+	return out, err
+}
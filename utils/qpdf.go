@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// qpdfTimeout bounds a single qpdf run when the caller's context has no
+// deadline of its own.
+const qpdfTimeout = 5 * time.Minute
+
+// RunQPDF runs qpdf and returns any warnings it printed. qpdf exits with
+// status 3 when it succeeded but had to work around problems in the input
+// (for example by reconstructing a broken xref table); that is reported as
+// success with warnings rather than as an error.
+func RunQPDF(ctx context.Context, args ...string) ([]string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, qpdfTimeout)
+		defer cancel()
+	}
+
+	out, err := exec.CommandContext(ctx, "qpdf", args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	var warnings []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			warnings = append(warnings, line)
+		}
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+		return warnings, nil
+	}
+	if err != nil {
+		return warnings, fmt.Errorf("qpdf failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return warnings, nil
+}
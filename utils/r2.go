@@ -71,7 +71,13 @@ func UploadToR2(key string, data []byte) (string, error) {
 }
 
 func UploadStreamToR2(key string, reader io.ReadSeeker) (string, error) {
-	fmt.Printf("[UploadStreamToR2] ➜ Streaming upload to R2: key = %s\n", key)
+	return UploadStreamToR2WithType(key, reader, "application/pdf")
+}
+
+// UploadStreamToR2WithType streams reader to R2 under key with the given
+// Content-Type, for results that are not PDFs.
+func UploadStreamToR2WithType(key string, reader io.ReadSeeker, contentType string) (string, error) {
+	fmt.Printf("[UploadStreamToR2] ➜ Streaming upload to R2: key = %s (%s)\n", key, contentType)
 
 	svc := R2Session()
 
@@ -80,7 +86,7 @@ func UploadStreamToR2(key string, reader io.ReadSeeker) (string, error) {
 		Key:         aws.String(key),
 		Body:        reader,
 		ACL:         aws.String("public-read"),
-		ContentType: aws.String("application/pdf"),
+		ContentType: aws.String(contentType),
 	})
 	if err != nil {
 		fmt.Println("[UploadStreamToR2] ❌ Streaming upload failed:", err)
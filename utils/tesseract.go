@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tesseractTimeout bounds a single tesseract run when the caller's context
+// has no deadline of its own.
+const tesseractTimeout = 5 * time.Minute
+
+// OCRResult is the outcome of recognising one page image.
+type OCRResult struct {
+	PDFPath    string  // text-only PDF page: invisible text, no image
+	Words      int     // recognised words
+	Confidence float64 // mean word confidence, 0 to 100
+}
+
+func runTesseract(ctx context.Context, args ...string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tesseractTimeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "tesseract", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("tesseract failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// TesseractLanguages lists the language packs installed for tesseract.
+func TesseractLanguages(ctx context.Context) ([]string, error) {
+	out, err := runTesseract(ctx, "--list-langs")
+	if err != nil {
+		return nil, err
+	}
+	var langs []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "List of available languages") {
+			continue
+		}
+		langs = append(langs, line)
+	}
+	return langs, nil
+}
+
+// OCRImage recognises the text on a page image rendered at dpi. It writes
+// outBase.pdf, a page of the same size holding only invisible text, ready
+// to be laid over the original page, and reads the word confidences from
+// outBase.tsv.
+func OCRImage(ctx context.Context, imagePath, outBase string, langs []string, dpi int) (OCRResult, error) {
+	_, err := runTesseract(ctx,
+		imagePath, outBase,
+		"-l", strings.Join(langs, "+"),
+		"--dpi", strconv.Itoa(dpi),
+		"-c", "textonly_pdf=1",
+		"pdf", "tsv",
+	)
+	if err != nil {
+		return OCRResult{}, err
+	}
+
+	f, err := os.Open(outBase + ".tsv")
+	if err != nil {
+		return OCRResult{}, err
+	}
+	defer f.Close()
+
+	res := OCRResult{PDFPath: outBase + ".pdf"}
+	var sum float64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// level page block par line word left top width height conf text
+		cols := strings.Split(sc.Text(), "\t")
+		if len(cols) < 12 || cols[0] != "5" || strings.TrimSpace(cols[11]) == "" {
+			continue
+		}
+		conf, err := strconv.ParseFloat(cols[10], 64)
+		if err != nil || conf < 0 {
+			continue
+		}
+		res.Words++
+		sum += conf
+	}
+	if err := sc.Err(); err != nil {
+		return OCRResult{}, fmt.Errorf("invalid tesseract output: %v", err)
+	}
+	if res.Words > 0 {
+		res.Confidence = sum / float64(res.Words)
+	}
+	return res, nil
+}
+
+// DetectOrientation runs tesseract's orientation detection on a page
+// image. rotate is how many degrees the page must be turned clockwise to
+// read upright; confidence is tesseract's own score, where values under 2
+// are little better than a guess. Pages with too little text to tell
+// report 0 for both.
+func DetectOrientation(ctx context.Context, imagePath string, dpi int) (rotate int, confidence float64, err error) {
+	out, err := runTesseract(ctx, imagePath, "stdout", "--psm", "0", "--dpi", strconv.Itoa(dpi))
+	if err != nil {
+		if strings.Contains(err.Error(), "Too few characters") {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Rotate":
+			rotate, _ = strconv.Atoi(value)
+		case "Orientation confidence":
+			confidence, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+	return rotate, confidence, nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// standaloneTimeout bounds unoconv runs that cold-start their own LibreOffice
+// because the worker pool is not available.
+const standaloneTimeout = 3 * time.Minute
+
+// ConvertWithUnoconv runs unoconv to convert inputPath into the given format,
+// writing the result to outputPath. Extra arguments (document type, import or
+// export filter options) are passed through before the input file.
+//
+// Conversions go through the shared LibreOffice pool when it is running and
+// fall back to a one-off unoconv process otherwise. Cancelling ctx aborts the
+// conversion in either case.
+func ConvertWithUnoconv(ctx context.Context, inputPath, outputPath, format string, args ...string) ([]byte, error) {
+	start := time.Now()
+	fmt.Printf("[ConvertWithUnoconv] ➜ Converting %s to %s\n", inputPath, format)
+
+	var (
+		out []byte
+		err error
+	)
+	if pool := DefaultOfficePool(); pool != nil {
+		out, err = pool.Convert(ctx, inputPath, outputPath, format, args...)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, standaloneTimeout)
+		defer cancel()
+
+		cmdArgs := []string{"-f", format, "-o", outputPath}
+		cmdArgs = append(cmdArgs, args...)
+		cmdArgs = append(cmdArgs, inputPath)
+
+		out, err = exec.CommandContext(ctx, "unoconv", cmdArgs...).CombinedOutput()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		fmt.Println("[ConvertWithUnoconv] ❌ Conversion failed:", err)
+		return out, err
+	}
+
+	fmt.Println("[ConvertWithUnoconv] ✅ Conversion done in", time.Since(start))
+	return out, nil
+}